@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lixmal/finddupes/pkg/misc"
+)
+
+// regexList is a flag that can be given multiple times, each adding a regex
+type regexList []*regexp.Regexp
+
+func (r *regexList) String() string {
+	var s []string
+	for _, re := range *r {
+		s = append(s, re.String())
+	}
+	return strings.Join(s, ", ")
+}
+
+func (r *regexList) Set(value string) error {
+	re, err := regexp.Compile(value)
+	if err != nil {
+		return err
+	}
+	*r = append(*r, re)
+	return nil
+}
+
+// stringList is a flag that can be given multiple times, each adding a string
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// sizeList is a flag that can be given multiple times, each adding a size like 4096 or 4K
+type sizeList []int64
+
+func (s *sizeList) String() string {
+	var sizes []string
+	for _, size := range *s {
+		sizes = append(sizes, strconv.FormatInt(size, 10))
+	}
+	return strings.Join(sizes, ", ")
+}
+
+func (s *sizeList) Set(value string) error {
+	size, err := misc.ParseSize(value)
+	if err != nil {
+		return err
+	}
+	*s = append(*s, size)
+	return nil
+}
+
+// byteSize is a flag holding a single size like 4096 or 4K
+type byteSize int64
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(value string) error {
+	size, err := misc.ParseSize(value)
+	if err != nil {
+		return err
+	}
+	*b = byteSize(size)
+	return nil
+}
+
+// aliasMap is a flag that can be given multiple times, each adding a key=value pair
+type aliasMap map[string]string
+
+func (a aliasMap) String() string {
+	var s []string
+	for key, value := range a {
+		s = append(s, key+"="+value)
+	}
+	sort.Strings(s)
+	return strings.Join(s, ", ")
+}
+
+func (a aliasMap) Set(value string) error {
+	key, alias, ok := strings.Cut(value, "=")
+	if !ok || key == "" || alias == "" {
+		return fmt.Errorf("expected key=value, got '%s'", value)
+	}
+	a[key] = alias
+	return nil
+}
+
+// modeMask is a flag that can be given multiple times, each adding a device type to the file mode mask.
+// Every device type has its own bit, see config.Config.IncludeModes.
+type modeMask os.FileMode
+
+var deviceModes = map[string]os.FileMode{
+	"block": os.ModeDevice,
+	"char":  os.ModeCharDevice,
+}
+
+func (m *modeMask) String() string {
+	var s []string
+	for name, mode := range deviceModes {
+		if os.FileMode(*m)&mode == mode {
+			s = append(s, name)
+		}
+	}
+	sort.Strings(s)
+	return strings.Join(s, ", ")
+}
+
+func (m *modeMask) Set(value string) error {
+	mode, ok := deviceModes[value]
+	if !ok {
+		return fmt.Errorf("unknown device type '%s', expected block or char", value)
+	}
+	*m |= modeMask(mode)
+	return nil
+}
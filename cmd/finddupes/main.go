@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
@@ -8,50 +9,285 @@ import (
 	"os"
 	"os/signal"
 	"regexp"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/lixmal/finddupes/pkg/config"
 	"github.com/lixmal/finddupes/pkg/dupe"
+	"github.com/lixmal/finddupes/pkg/file"
+	"github.com/lixmal/finddupes/pkg/version"
 )
 
 const (
 	workers int = 10
 )
 
+// exit codes with -exitcode
+const (
+	exitDuplicates = 1
+	exitError      = 2
+)
+
 var (
 	storeonly = flag.Bool("storeonly", false, "store hashes to database without trying to find duplicates")
+	prune     = flag.Bool("prune", false, "only remove files that no longer exist from the database")
+
+	listmissing = flag.Bool("listmissing", false, "only list files of the database that no longer exist, without modifying anything")
+
+	dump = flag.Bool("dump", false, "only print all files of the database grouped by hash, with size, mtime and mode, without modifying anything")
+
+	verifyintegrity = flag.Bool("verifyintegrity", false, "only hash the files of the database again and list those whose content changed while their mtime didn't, e.g. by bit rot, without modifying anything")
+
+	sethash = flag.String("sethash", "", "only print a fingerprint of the hashed files of the database to compare databases: paths (paths and hashes) or content (hashes only)")
 
 	delete  = flag.Bool("delete", false, "delete duplicates based on rules")
-	verbose = flag.Bool("verbose", false, "enable verbose messages")
+	verbose = flag.Bool("verbose", false, "enable verbose messages, same as -loglevel 2")
+
+	loglevel = flag.Int("loglevel", 1, "verbosity of messages: 0 quiet, 1 normal, 2 verbose (every file) or 3 debug (hashes)")
+
+	showprogress = flag.Bool("progress", true, "show a progress bar while hashing, disabled if stdout isn't a terminal or -verbose is given")
+
+	exitcode = flag.Bool("exitcode", false, "exit with 1 if duplicates were found, 0 if none and 2 on errors")
 
-	path = flag.String("path", "", "path to the hash database, will be read/written to/from if specified")
+	printversion = flag.Bool("version", false, "print the version and exit")
+
+	path     = flag.String("path", "", "path to the hash database, will be read/written to/from if specified")
+	dbformat = flag.String("dbformat", "gob", "format of the hash database: gob or json")
+
+	tempdir = flag.String("tempdir", "", "write the database to this directory first, before moving it into place, e.g. if its own directory is short of space")
+
+	dbnofollow = flag.Bool("dbnofollow", false, "refuse a database path that is a symlink instead of writing to its target")
 
 	delmatch  = flag.String("delmatch", "", "delete duplicates files matching the given regex")
 	keepmatch = flag.String("keepmatch", "", "delete all duplicate files except those matching the given regex")
+	junkmatch = flag.String("junkmatch", "", "delete duplicates matching the given regex if their group has a copy not matching it, which is kept")
+
+	matchtarget = flag.String("matchtarget", config.MatchWalked, "path the regex rules match against: walked (as given), absolute or relative (to the scanned path)")
 
 	keepfirst = flag.Bool("keepfirst", false, "keep lexically first file and delete all others")
 	keeplast  = flag.Bool("keeplast", false, "keep lexically last file and delete all others")
 
 	keepoldest = flag.Bool("keepoldest", false, "keep oldest file and delete all others")
+
 	keeprecent = flag.Bool("keeprecent", false, "keep most recent file and delete all others")
+
+	keeprecentaccess = flag.Bool("keeprecentaccess", false, "keep most recently accessed file and delete all others")
+	keepoldestaccess = flag.Bool("keepoldestaccess", false, "keep least recently accessed file and delete all others")
+
+	keeplargest  = flag.Bool("keeplargest", false, "keep largest file and delete all others, requires -normalizecmd or -normalizetext")
+	keepsmallest = flag.Bool("keepsmallest", false, "keep smallest file and delete all others, requires -normalizecmd or -normalizetext")
+
+	keeprestrictive = flag.Bool("keeprestrictive", false, "keep file with the fewest permission bits and delete all others, e.g. 0600 over 0644")
+	keeppermissive  = flag.Bool("keeppermissive", false, "keep file with the most permission bits and delete all others")
+
+	keepuser  = flag.String("keepuser", "", "keep all files owned by the given user name or id, delete duplicates owned by others")
+	keepgroup = flag.String("keepgroup", "", "keep all files owned by the given group name or id, delete duplicates owned by others")
+
+	keeptagged = flag.Bool("keeptagged", false, "keep all files with user extended attributes (user.* or Finder tags), delete duplicates without")
+	keeptag    = flag.String("keeptag", "", "only consider files tagged with this extended attribute for -keeptagged, e.g. user.xdg.tags")
+
+	linkmode           = flag.String("linkmode", config.LinkModeDelete, "how duplicates are removed: delete, hardlink (replace with a hard link to the kept file), stub (replace with an empty file) or trash (move to the desktop trash)")
+	linksamedevonly    = flag.Bool("linksamedevonly", false, "only hardlink duplicates on the same device as the kept file, skip others")
+	linkfallbackdelete = flag.Bool("linkfallbackdelete", false, "delete duplicates that can't be hardlinked because of -linksamedevonly")
+
+	confirm = flag.Bool("confirm", false, "print a summary of all deletions and ask once before deleting anything")
+
+	deleteworkers = flag.Int("deleteworkers", 1, "number of duplicates removed in parallel")
+
+	maxdeletebytes = flag.Int64("maxdeletebytes", 0, "stop deleting once this many bytes would be freed, 0 means unlimited")
+
+	mintotaldupes = flag.Int("mintotaldupes", 0, "delete nothing if fewer duplicates than this were found in total, 0 disables the check")
+
+	script = flag.String("script", "", "write a shell script removing the duplicates to this path instead of deleting them")
+
+	pruneemptydirs = flag.Bool("pruneemptydirs", false, "remove directories left empty after deleting duplicates")
+
+	hashalgo    = flag.String("hashalgo", config.HashAlgorithmXXHash, "hash algorithm: xxhash or blake3")
+	hashkeyfile = flag.String("hashkeyfile", "", "key blake3 hashes with the 32 bytes read from this file, given as 64 hex characters")
+
+	refhashes = flag.String("refhashes", "", "treat the content listed in this file of 'hash size' lines as reference files, duplicates of it are deleted")
+
+	hashmanifest = flag.String("hashmanifest", "", "take hashes of files not modified since over from this file of 'hash  path' lines, e.g. written by xxhsum or b3sum")
+
+	hashall = flag.Bool("hashall", false, "hash every file, not only files sharing their size with another one, e.g. to compare databases with -sethash")
+
+	hashorder  = flag.String("hashorder", config.HashOrderNone, "order in which files are hashed: none, largest-first or smallest-first")
+	queuedepth = flag.Int("queuedepth", 0, "number of files queued for hashing, 0 means 4 per worker, negative unbuffered")
+
+	hashretries    = flag.Int("hashretries", 0, "retry hashing a file this many times after transient errors, e.g. timeouts of network filesystems")
+	hashretrydelay = flag.Duration("hashretrydelay", time.Second, "delay before the first retry of -hashretries, doubled for every further retry")
+
+	maxopenfiles = flag.Int("maxopenfiles", 0, "maximum number of files opened concurrently for hashing, 0 derives it from the open files limit, -1 is unlimited")
+
+	quiet   = flag.Bool("quiet", false, "don't report duplicate groups and removals, only warnings and the summary, same as -loglevel 0")
+	hashlen = flag.Int("hashlen", 0, "only print this many hex digits of hashes in the report, 0 prints them in full")
+
+	actedonly = flag.Bool("actedonly", false, "only report groups with files to delete, omit groups whose files are all kept")
+
+	output = flag.String("output", config.OutputText, "output format: text, null (paths of files to delete, NUL terminated), kept (paths of kept files, one per line) or fdupes (paths of each group, groups separated by blank lines)")
+
+	strictrules  = flag.Bool("strictrules", false, "abort before deleting if a rule keeping a single file and -delmatch or -keepmatch disagree on the file to keep")
+	strictroots  = flag.Bool("strictroots", false, "abort if any given path doesn't exist instead of skipping it")
+	dedupbyinode = flag.String("dedupbyinode", "", "handle the same file found under different paths: skip or alias (record as an alias of the first path)")
+
+	detectmime = flag.Bool("detectmime", false, "detect and store the content type of hashed files")
+	mimefilter = flag.String("mimefilter", "", "only consider duplicates whose content type matches the given pattern, e.g. 'image/*'")
+
+	hashshard = flag.String("hashshard", "", "only handle the duplicate groups of this shard of the hashes, given as index/count, e.g. 0/4")
+
+	prefixonly  = flag.Bool("prefixonly", false, "only hash the first bytes of each file, fast but approximate, deletion is refused")
+	prefixbytes = flag.Int64("prefixbytes", 64*1024, "number of bytes hashed in prefix only mode")
+
+	normalizecmd  = flag.String("normalizecmd", "", "hash the output of this command instead of the file content, {} is replaced by the path, deletion is refused")
+	normalizetext = flag.Bool("normalizetext", false, "hash text files ignoring CRLF line endings and trailing whitespace, deletion is refused")
+
+	samename = flag.Bool("samename", false, "only consider duplicates with the same file name")
+
+	maxage = flag.Duration("maxage", 0, "skip files modified longer ago than this duration, e.g. 720h")
+
+	timewindow   = flag.Duration("timewindow", 0, "only consider duplicates modified within this duration of each other, e.g. 1h")
+	strictwindow = flag.Bool("strictwindow", false, "don't chain files of -timewindow, all files of a group are within the window of its oldest one")
+
+	scanarchives = flag.Bool("scanarchives", false, "also compare the entries of zip and tar archives, deletion is refused")
+
+	keeppartialhashes = flag.Bool("keeppartialhashes", false, "record hashes of files that failed to read completely as partial instead of dropping them")
+
+	samplefraction = flag.Float64("samplefraction", 0, "only hash this fraction (0-1) of equally sized files and estimate the duplicates, e.g. 0.05")
+
+	detectmoves = flag.Bool("detectmoves", false, "reuse the hash of a file that vanished since the last run for a new file that looks like it was moved")
+
+	incremental = flag.Bool("incremental", false, "don't read directories again whose mtime didn't change since the last run, requires -path")
+	checkpoint  = flag.Bool("checkpoint", false, "write the database every minute while indexing, so an interrupted run resumes where it stopped, requires -path")
+
+	runid = flag.String("runid", "", "record this id on every file found while indexing as the last run that saw it")
+
+	includeempty  = flag.Bool("includeempty", false, "also index empty files, which are skipped by default")
+	emptydistinct = flag.Bool("emptydistinct", false, "report empty files, but never delete them as duplicates of each other, requires -includeempty")
+
+	skiphidden  = flag.Bool("skiphidden", false, "skip hidden files and don't descend into hidden directories")
+	excludefile = flag.String("excludefile", "", "skip paths listed in this file, one path prefix or glob pattern per line")
+
+	interactive = flag.Bool("interactive", false, "choose the file to keep of each duplicate group, read from stdin")
+)
+
+var (
+	keeppriority regexList
+	reference    stringList
+	referencedb  stringList
+	allowdelete  stringList
+	extalias     aliasMap = aliasMap{}
+	ignoresize   sizeList
+	includemode  modeMask
+	maxreadrate  byteSize
 )
 
+// stage is the single stage given as subcommand, empty runs all stages
+var stage string
+
 func init() {
-	flag.Parse()
+	flag.Var(&keeppriority, "keeppriority", "keep the first file matching the given regex, can be given multiple times in descending order of preference")
+	flag.Var(&reference, "reference", "path whose files are never deleted, but whose duplicates elsewhere are, can be given multiple times")
+	flag.Var(&referencedb, "referencedb", "database whose hashed files are reference files, read only, can be given multiple times")
+	flag.Var(&allowdelete, "allowdelete", "only delete files below the given path, can be given multiple times")
+	flag.Var(&extalias, "extalias", "treat extensions as equivalent for -samename, e.g. jpeg=jpg, can be given multiple times")
+	flag.Var(&ignoresize, "ignoresize", "ignore files of exactly this size, e.g. 4096 or 4K, can be given multiple times")
+	flag.Var(&maxreadrate, "maxreadrate", "limit the bytes read per second for hashing by all workers together, e.g. 50M, 0 means unlimited")
+	flag.Var(&includemode, "includemode", "also index devices of this type, block or char, which are never deleted, can be given multiple times")
+
+	// a stage given as subcommand precedes the flags
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case config.StageIndex, config.StageHash, config.StageDelete:
+			stage, args = args[0], args[1:]
+		}
+	}
+	// exits on errors
+	_ = flag.CommandLine.Parse(args)
 }
 
 func main() {
+	if *printversion {
+		fmt.Println(version.Get())
+		return
+	}
+
 	args := flag.Args()
 
+	if *prune && *path == "" {
+		fatalf("Prune given, but no path specified\n")
+	}
+	if *listmissing && *path == "" {
+		fatalf("Listmissing given, but no path specified\n")
+	}
+	if *dump && *path == "" {
+		fatalf("Dump given, but no path specified\n")
+	}
+	if *verifyintegrity && *path == "" {
+		fatalf("Verifyintegrity given, but no path specified\n")
+	}
+	if *sethash != "" {
+		if *path == "" {
+			fatalf("Sethash given, but no path specified\n")
+		}
+		if *sethash != "paths" && *sethash != "content" {
+			fatalf("Invalid set hash '%s', expected paths or content\n", *sethash)
+		}
+	}
+
+	level := *loglevel
+	if level < 0 || level > 3 {
+		fatalf("Invalid log level %d, expected 0 to 3\n", level)
+	}
+	if *verbose && level < 2 {
+		level = 2
+	}
+	if *quiet {
+		level = 0
+	}
+	verbosity := config.LogLevel(level)
+
+	var hashkey []byte
+	if *hashkeyfile != "" {
+		key, err := readHashKey(*hashkeyfile)
+		if err != nil {
+			fatalf("Failed to read hash key: %s\n", err)
+		}
+		hashkey = key
+	}
+
+	if stage != "" {
+		if *path == "" {
+			fatalf("Stage %s given, but no path specified\n", stage)
+		}
+		if stage == config.StageIndex && len(args) == 0 {
+			fatalf("Stage index given, but no directories provided\n")
+		}
+		if stage != config.StageIndex && len(args) > 0 {
+			fatalf("Stage %s works on the database only, no directories expected\n", stage)
+		}
+	}
+
 	if *storeonly {
 		if *path == "" {
-			log.Fatal("Storeonly given, but no path specified\n")
+			fatalf("Storeonly given, but no path specified\n")
 		}
 		if len(args) == 0 {
-			log.Fatal("Storeonly given, but no directories provided\n")
+			fatalf("Storeonly given, but no directories provided\n")
 		}
 	}
 
+	switch *output {
+	case config.OutputText, config.OutputNull, config.OutputKept, config.OutputFdupes:
+	default:
+		fatalf("Unknown output format: %s\n", *output)
+	}
+
+	if *keeptagged && !file.XattrSupported {
+		log.Println("Warning: extended attributes aren't supported on this platform, no files are considered tagged")
+	}
+
 	var reDelMatch *regexp.Regexp
 	var reKeepMatch *regexp.Regexp
 	if *delmatch != "" {
@@ -60,25 +296,143 @@ func main() {
 	if *keepmatch != "" {
 		reKeepMatch = regexp.MustCompile(*keepmatch)
 	}
+	var reJunkMatch *regexp.Regexp
+	if *junkmatch != "" {
+		reJunkMatch = regexp.MustCompile(*junkmatch)
+	}
 
 	conf := config.Config{
 		StoreOnly:  *storeonly,
 		Path:       *path,
+		OnlyStage:  stage,
+		DBFormat:   *dbformat,
+		DBNoFollow: *dbnofollow,
+		TempDir:    *tempdir,
 		Delete:     *delete,
-		Verbose:    *verbose,
 		DelMatch:   reDelMatch,
 		KeepMatch:  reKeepMatch,
+		JunkMatch:  reJunkMatch,
 		KeepFirst:  *keepfirst,
 		KeepLast:   *keeplast,
 		KeepOldest: *keepoldest,
 		KeepRecent: *keeprecent,
-		Workers:    workers,
+
+		KeepRecentAccess: *keeprecentaccess,
+		KeepOldestAccess: *keepoldestaccess,
+
+		KeepLargestFile:  *keeplargest,
+		KeepSmallestFile: *keepsmallest,
+
+		KeepMostRestrictive:  *keeprestrictive,
+		KeepLeastRestrictive: *keeppermissive,
+
+		KeepUser:  *keepuser,
+		KeepGroup: *keepgroup,
+
+		KeepTagged: *keeptagged,
+		KeepTag:    *keeptag,
+
+		MatchTarget: *matchtarget,
+
+		KeepPriority:   keeppriority,
+		ReferencePaths: reference,
+		ReferenceDBs:   referencedb,
+
+		DeleteAllowedRoots: allowdelete,
+
+		Workers:     workers,
+		QueueDepth:  *queuedepth,
+		HashOrder:   *hashorder,
+		HashAll:     *hashall,
+		SkipHidden:  *skiphidden,
+		ExcludeFile: *excludefile,
+		MaxAge:      *maxage,
+
+		IncludeModes: os.FileMode(includemode),
+
+		HashAlgorithm: *hashalgo,
+		HashKey:       hashkey,
+		HashManifest:  *hashmanifest,
+
+		ReferenceHashes: *refhashes,
+
+		HashRetries:    *hashretries,
+		HashRetryDelay: *hashretrydelay,
+
+		MaxReadBytesPerSec: int64(maxreadrate),
+		MaxOpenFiles:       *maxopenfiles,
+
+		IgnoreSizes:     ignoresize,
+		IncludeEmpty:    *includeempty,
+		EmptyDistinct:   *emptydistinct,
+		IncrementalWalk: *incremental,
+		DetectMoves:     *detectmoves,
+		IndexCheckpoint: *checkpoint,
+		RunID:           *runid,
+
+		StrictRoots:  *strictroots,
+		StrictRules:  *strictrules,
+		DedupByInode: *dedupbyinode,
+
+		PruneEmptyDirs: *pruneemptydirs,
+		ScriptPath:     *script,
+		MaxDeleteBytes: *maxdeletebytes,
+		DeleteWorkers:  *deleteworkers,
+		ConfirmOnce:    *confirm,
+
+		MinTotalDuplicates: *mintotaldupes,
+
+		LinkMode:           *linkmode,
+		LinkSameDevOnly:    *linksamedevonly,
+		LinkFallbackDelete: *linkfallbackdelete,
+
+		OutputFormat: *output,
+		Verbosity:    verbosity,
+
+		HashDisplayLen:  *hashlen,
+		ReportActedOnly: *actedonly,
+
+		PrefixOnly:  *prefixonly,
+		PrefixBytes: *prefixbytes,
+
+		SameName:   *samename,
+		TimeWindow: *timewindow,
+		ExtAliases: extalias,
+
+		StrictTimeWindow: *strictwindow,
+
+		NormalizeCmd: *normalizecmd,
+		ScanArchives: *scanarchives,
+
+		NormalizeText: *normalizetext,
+
+		KeepPartialHashes: *keeppartialhashes,
+		SampleFraction:    *samplefraction,
+
+		DetectMime: *detectmime,
+		MimeFilter: *mimefilter,
+		HashShard:  *hashshard,
+	}
+
+	// the bar would interleave with other output
+	if *showprogress && verbosity < config.VerbosityVerbose && *output == config.OutputText && isTerminal(os.Stdout) {
+		bar := newProgressBar(os.Stdout)
+		conf.OnProgress = bar.update
+		conf.OnBytes = bar.updateFile
+	}
+
+	// prompts go to stderr, to keep the output usable with -output
+	if *interactive {
+		conf.KeepSelector = newSelector(os.Stdin, os.Stderr)
 	}
 
 	dup := dupe.New(conf)
 
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP)
+	// writes to a closed pipe, e.g. of head, fail with EPIPE instead of killing the process,
+	// so the run stops gracefully and the database is still written
+	signal.Ignore(syscall.SIGPIPE)
 
 	go func() {
 		sig := <-sigs
@@ -86,7 +440,117 @@ func main() {
 		dup.Stop()
 	}()
 
+	if *prune {
+		pruned, err := dup.Prune()
+		if errors.Is(err, dupe.ErrProcessStopped) {
+			fmt.Println("Stopped, the database was left unchanged")
+			return
+		}
+		if err != nil {
+			fatalf("Failed to prune database: %s\n", err)
+		}
+		fmt.Printf("Removed %d vanished files from the database\n", pruned)
+		return
+	}
+
+	if *listmissing {
+		missing, err := dup.MissingFiles()
+		if err != nil && !errors.Is(err, dupe.ErrProcessStopped) {
+			fatalf("Failed to list missing files: %s\n", err)
+		}
+		for _, fil := range missing {
+			fmt.Println(fil.Path)
+		}
+		return
+	}
+
+	if *dump {
+		if err := dup.DumpDatabase(os.Stdout); err != nil {
+			fatalf("Failed to dump database: %s\n", err)
+		}
+		return
+	}
+
+	if *verifyintegrity {
+		changed, err := dup.VerifyIntegrity()
+		if err != nil && !errors.Is(err, dupe.ErrProcessStopped) {
+			fatalf("Failed to verify integrity: %s\n", err)
+		}
+		for _, fil := range changed {
+			fmt.Println(fil.Path)
+		}
+		if *exitcode && len(changed) > 0 {
+			os.Exit(exitDuplicates)
+		}
+		return
+	}
+
+	if *sethash != "" {
+		sum, err := dup.SetHash(*sethash == "content")
+		if err != nil {
+			fatalf("Failed to calculate set hash: %s\n", err)
+		}
+		fmt.Println(sum)
+		return
+	}
+
 	if err := dup.ProcessFiles(args); err != nil && !errors.Is(err, dupe.ErrProcessStopped) {
-		log.Fatalf("Failed to process files: %s\n", err)
+		fatalf("Failed to process files: %s\n", err)
+	}
+
+	if verbosity >= config.VerbosityVerbose {
+		// machine-readable output only carries results
+		out := os.Stdout
+		if *output != config.OutputText {
+			out = os.Stderr
+		}
+		for i, stats := range dup.Stats() {
+			fmt.Fprintf(out, "Worker %d hashed %d files, %s in %s\n", i, stats.Files, formatBytes(stats.Bytes), stats.Busy.Round(time.Millisecond))
+		}
+	}
+
+	printSummary(dup, *output)
+
+	// skipped hash or delete errors make the result incomplete
+	if *exitcode && len(dup.Errors()) > 0 {
+		os.Exit(exitError)
+	}
+	if *exitcode && dup.Summary().Groups > 0 {
+		os.Exit(exitDuplicates)
+	}
+}
+
+// fatalf logs the message and exits with the error exit code
+func fatalf(format string, a ...any) {
+	log.Printf(format, a...)
+	if *exitcode {
+		os.Exit(exitError)
+	}
+	os.Exit(1)
+}
+
+// readHashKey reads a hex encoded hash key from the file
+func readHashKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("decode '%s': %w", path, err)
+	}
+	return key, nil
+}
+
+// printSummary prints the summary of the run as the last line of stdout, a single line of key=value pairs.
+// It's printed with every output format, so supervising processes find it in the same place.
+func printSummary(dup *dupe.Dupe, format string) {
+	// keep machine-readable output parseable
+	out := os.Stdout
+	if format != config.OutputText {
+		out = os.Stderr
 	}
+	summary := dup.Summary()
+	fmt.Fprintf(out, "SUMMARY groups=%d files=%d freed_bytes=%d reclaimable_bytes=%d shared_bytes=%d dry_run=%t errors=%d\n",
+		summary.Groups, summary.Removed, summary.FreedBytes, summary.ReclaimableBytes, summary.SharedBytes, summary.DryRun, len(dup.Errors()))
 }
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lixmal/finddupes/pkg/config"
+)
+
+const (
+	barWidth       = 30
+	renderInterval = 100 * time.Millisecond
+)
+
+// progressBar renders the hashing progress on a single, continuously rewritten line
+type progressBar struct {
+	w          io.Writer
+	start      time.Time
+	lastRender time.Time
+	finished   bool
+	// last is the progress rendered last, the file is the large file being hashed
+	last      config.Progress
+	file      string
+	fileDone  int64
+	fileTotal int64
+}
+
+func newProgressBar(w io.Writer) *progressBar {
+	return &progressBar{w: w}
+}
+
+// isTerminal reports whether f is a character device, e.g. a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// update renders the progress, it is used as config.Config.OnProgress
+func (p *progressBar) update(progress config.Progress) {
+	if progress.Stage != config.StageHash || progress.Total == 0 {
+		return
+	}
+
+	now := time.Now()
+	if p.start.IsZero() {
+		p.start = now
+	}
+	p.last = progress
+
+	done := progress.Done >= progress.Total
+	// limit redraws, but always draw the final state
+	if !done && now.Sub(p.lastRender) < renderInterval {
+		return
+	}
+	p.lastRender = now
+
+	fmt.Fprintf(p.w, "\r%s", p.render(progress, now.Sub(p.start)))
+
+	if done && !p.finished {
+		p.finished = true
+		fmt.Fprintln(p.w)
+	}
+}
+
+// updateFile renders the progress of a large file being hashed, it is used as config.Config.OnBytes
+func (p *progressBar) updateFile(path string, done, total int64) {
+	p.file, p.fileDone, p.fileTotal = filepath.Base(path), done, total
+
+	now := time.Now()
+	if p.last.Total == 0 || p.finished || now.Sub(p.lastRender) < renderInterval {
+		return
+	}
+	p.lastRender = now
+
+	fmt.Fprintf(p.w, "\r%s", p.render(p.last, now.Sub(p.start)))
+}
+
+// render formats the progress line
+func (p *progressBar) render(progress config.Progress, elapsed time.Duration) string {
+	filled := barWidth * progress.Done / progress.Total
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled)
+
+	line := fmt.Sprintf("[%s] %d/%d files", bar, progress.Done, progress.Total)
+	if p.fileTotal > 0 && p.fileDone < p.fileTotal {
+		line += fmt.Sprintf("  %s %d%%", p.file, 100*p.fileDone/p.fileTotal)
+	}
+
+	seconds := elapsed.Seconds()
+	if seconds <= 0 || progress.Bytes == 0 {
+		return line
+	}
+
+	rate := float64(progress.Bytes) / seconds
+	line += fmt.Sprintf("  %s/s", formatBytes(int64(rate)))
+
+	if remaining := progress.TotalBytes - progress.Bytes; remaining > 0 {
+		eta := time.Duration(float64(remaining) / rate * float64(time.Second))
+		line += fmt.Sprintf("  ETA %s", eta.Round(time.Second))
+	}
+
+	// overwrite leftovers of longer lines
+	return line + "   "
+}
+
+// formatBytes formats a byte count with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
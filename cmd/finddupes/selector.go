@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lixmal/finddupes/pkg/dupe"
+	"github.com/lixmal/finddupes/pkg/file"
+	"golang.org/x/term"
+)
+
+const (
+	keyUp = iota + 256
+	keyDown
+)
+
+// selector lets the user choose the file to keep of each duplicate group
+type selector struct {
+	in     *os.File
+	reader *bufio.Reader
+	out    io.Writer
+}
+
+// newSelector returns a selector for config.KeepSelector reading from in.
+// In a terminal files are chosen with the arrow keys, otherwise by number, one answer per line.
+func newSelector(in *os.File, out io.Writer) func(file.Slice) (int, error) {
+	s := &selector{in: in, reader: bufio.NewReader(in), out: out}
+	if term.IsTerminal(int(in.Fd())) {
+		return s.selectTerminal
+	}
+	return s.selectLine
+}
+
+// selectTerminal shows the files of the group and moves a cursor with the arrow keys until one is chosen
+func (s *selector) selectTerminal(files file.Slice) (int, error) {
+	fd := int(s.in.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return 0, fmt.Errorf("set terminal mode: %w", err)
+	}
+	defer term.Restore(fd, state)
+
+	// raw mode doesn't translate newlines
+	fmt.Fprintf(s.out, "Choose the file to keep (up/down, enter to keep, s to keep all, q to quit):\r\n")
+
+	cursor := 0
+	for {
+		for i, fil := range files {
+			marker := " "
+			if i == cursor {
+				marker = ">"
+			}
+			fmt.Fprintf(s.out, "\x1b[2K%s %s\r\n", marker, describeFile(fil))
+		}
+
+		key, err := s.readKey()
+		if err != nil {
+			return 0, fmt.Errorf("read selection: %w", err)
+		}
+
+		switch key {
+		case keyUp, 'k':
+			cursor = (cursor + len(files) - 1) % len(files)
+		case keyDown, 'j':
+			cursor = (cursor + 1) % len(files)
+		case '\r', '\n':
+			return cursor, nil
+		case 's':
+			return -1, nil
+		case 'q', 3: // Ctrl-c doesn't raise a signal in raw mode
+			return 0, dupe.ErrProcessStopped
+		}
+
+		// redraw the list in place
+		fmt.Fprintf(s.out, "\x1b[%dA", len(files))
+	}
+}
+
+// readKey reads a single key press, translating the escape sequences of the arrow keys
+func (s *selector) readKey() (int, error) {
+	b, err := s.reader.ReadByte()
+	if err != nil || b != 0x1b {
+		return int(b), err
+	}
+
+	seq := make([]byte, 2)
+	if _, err := io.ReadFull(s.reader, seq); err != nil {
+		return 0, err
+	}
+	switch string(seq) {
+	case "[A", "OA":
+		return keyUp, nil
+	case "[B", "OB":
+		return keyDown, nil
+	}
+	return 0, nil
+}
+
+// selectLine lists the files of the group numbered and reads the number of the file to keep
+func (s *selector) selectLine(files file.Slice) (int, error) {
+	for i, fil := range files {
+		fmt.Fprintf(s.out, "  [%d] %s\n", i+1, describeFile(fil))
+	}
+
+	for {
+		fmt.Fprintf(s.out, "Keep which file? [1-%d, s to keep all, q to quit]: ", len(files))
+
+		line, err := s.reader.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return 0, fmt.Errorf("read selection: %w", err)
+		}
+
+		switch answer := strings.TrimSpace(line); answer {
+		case "s":
+			return -1, nil
+		case "q":
+			return 0, dupe.ErrProcessStopped
+		default:
+			n, convErr := strconv.Atoi(answer)
+			if convErr == nil && n >= 1 && n <= len(files) {
+				return n - 1, nil
+			}
+			if err != nil {
+				return 0, fmt.Errorf("read selection: invalid answer '%s'", answer)
+			}
+			fmt.Fprintf(s.out, "Invalid answer '%s'\n", answer)
+		}
+	}
+}
+
+// describeFile formats the size, modification time and path of a file for selection
+func describeFile(fil *file.File) string {
+	return fmt.Sprintf("%10s  %s  %s", formatBytes(fil.Size), fil.MTime.Format("2006-01-02 15:04:05"), fil.Path)
+}
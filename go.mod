@@ -2,4 +2,11 @@ module github.com/lixmal/finddupes
 
 go 1.18
 
-require github.com/cespare/xxhash v1.1.0
+require (
+	github.com/cespare/xxhash v1.1.0
+	golang.org/x/sys v0.10.0
+	golang.org/x/term v0.10.0
+	lukechampine.com/blake3 v1.1.7
+)
+
+require github.com/klauspost/cpuid/v2 v2.0.12 // indirect
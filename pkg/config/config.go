@@ -1,6 +1,17 @@
 package config
 
-import "regexp"
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lixmal/finddupes/pkg/file"
+)
 
 const (
 	ModeOnTheFly = iota
@@ -8,16 +19,349 @@ const (
 	ModeStore
 )
 
+// Verbosity is the level of messages printed, the zero value is VerbosityNormal.
+// Use LogLevel to convert the levels numbered from 0 (quiet) to 3 (debug).
+type Verbosity int
+
+const (
+	// VerbosityQuiet suppresses the report of duplicate groups and removals in the text output
+	VerbosityQuiet Verbosity = iota - 1
+	// VerbosityNormal reports duplicate groups and removals
+	VerbosityNormal
+	// VerbosityVerbose additionally reports every processed file
+	VerbosityVerbose
+	// VerbosityDebug additionally prints the hash of every file and other internals
+	VerbosityDebug
+)
+
+// LogLevel returns the verbosity of the level numbered from 0 (quiet) to 3 (debug)
+func LogLevel(level int) Verbosity {
+	return VerbosityQuiet + Verbosity(level)
+}
+
+const (
+	StageIndex  = "index"
+	StageHash   = "hash"
+	StageDelete = "delete"
+)
+
+const (
+	// OutputText is the human readable report
+	OutputText = "text"
+	// OutputNull prints only the paths of files matching deletion rules, each terminated by a NUL byte
+	OutputNull = "null"
+	// OutputKept prints only the paths of files kept in each duplicate group, one per line
+	OutputKept = "kept"
+	// OutputFdupes prints the paths of each duplicate group one per line, each group followed by a blank line, like fdupes
+	OutputFdupes = "fdupes"
+)
+
+const (
+	HashAlgorithmXXHash = "xxhash"
+	// HashAlgorithmBlake3 is a cryptographic hash, optionally keyed with HashKey
+	HashAlgorithmBlake3 = "blake3"
+
+	// HashKeyLen is the length of blake3 keys
+	HashKeyLen = 32
+)
+
+const (
+	HashOrderNone          = "none"
+	HashOrderLargestFirst  = "largest-first"
+	HashOrderSmallestFirst = "smallest-first"
+)
+
+const (
+	// MatchAbsolute matches the patterns against the absolute path of files
+	MatchAbsolute = "absolute"
+	// MatchRelative matches the patterns against the path relative to the scanned path a file was found under
+	MatchRelative = "relative"
+	// MatchWalked matches the patterns against the path as walked, relative if the scanned path is, the default
+	MatchWalked = "walked"
+)
+
+const (
+	// DedupByInodeSkip ignores further paths of an already indexed inode
+	DedupByInodeSkip = "skip"
+	// DedupByInodeAlias records further paths of an already indexed inode as aliases of the indexed file
+	DedupByInodeAlias = "alias"
+)
+
+const (
+	// LinkModeDelete deletes duplicates
+	LinkModeDelete = "delete"
+	// LinkModeHardlink replaces duplicates with hard links to the kept file
+	LinkModeHardlink = "hardlink"
+	// LinkModeStub replaces duplicates with empty files, e.g. to keep sync tools from fetching them again
+	LinkModeStub = "stub"
+	// LinkModeTrash moves duplicates to the freedesktop.org trash, so they can be restored from file managers
+	LinkModeTrash = "trash"
+)
+
+// Progress describes how far a processing stage has advanced.
+// Total is 0 if unknown.
+// Bytes and TotalBytes are the sizes of the processed and all files, only set for the hash stage.
+type Progress struct {
+	Stage      string
+	Done       int
+	Total      int
+	Bytes      int64
+	TotalBytes int64
+}
+
 type Config struct {
-	StoreOnly  bool
-	Path       string
+	StoreOnly bool
+	Path      string
+	// OnlyStage runs a single stage of processing on the database: StageIndex only indexes the given paths,
+	// StageHash hashes the indexed files and StageDelete handles the duplicates among the hashed files.
+	// Stages may run at different times, but use the same database. Empty runs all stages.
+	OnlyStage string
+	// DBNoFollow refuses a database path that is a symlink, instead of writing through to its target
+	DBNoFollow bool
+	// TempDir is the directory the database is written to before it's moved into place, defaults to the directory
+	// of the database. It may be on another filesystem, at the cost of copying the written database.
+	TempDir string
+	// DBFormat is the database encoding, gob (default) or json
+	DBFormat   string
 	Delete     bool
-	Verbose    bool
 	DelMatch   *regexp.Regexp
 	KeepMatch  *regexp.Regexp
 	KeepFirst  bool
 	KeepLast   bool
 	KeepOldest bool
 	KeepRecent bool
-	Workers    int
+	// KeepRecentAccess and KeepOldestAccess use the current access time of files,
+	// or the one recorded before reading them if they were hashed in this run
+	KeepRecentAccess bool
+	KeepOldestAccess bool
+	// KeepLargestFile and KeepSmallestFile keep the largest or smallest file of a group, the lexically first on ties.
+	// Only duplicates by normalize command output can differ in size.
+	KeepLargestFile  bool
+	KeepSmallestFile bool
+	// KeepMostRestrictive and KeepLeastRestrictive keep the file with the fewest or most permission bits granted,
+	// the lexically first on ties
+	KeepMostRestrictive  bool
+	KeepLeastRestrictive bool
+	// StrictRules fails before deleting anything if the positional rules and the pattern rules disagree on the file
+	// to keep in any group. Otherwise a warning is printed and both files are kept.
+	StrictRules bool
+	// KeepPriority lists patterns in descending order of preference
+	KeepPriority []*regexp.Regexp
+	// KeepUser and KeepGroup keep all files owned by the given user or group, by name or id.
+	// Duplicates owned by others are deleted if an owned copy exists.
+	// The ownership recorded when indexing is used.
+	KeepUser  string
+	KeepGroup string
+	// KeepTagged keeps all files with user extended attributes (user.* or Finder tags), or with the KeepTag attribute if set.
+	// Duplicates without are deleted if a tagged copy exists.
+	// Extended attributes are read on Linux, macOS, FreeBSD and NetBSD only.
+	KeepTagged bool
+	KeepTag    string
+	// JunkMatch marks files whose path matches as junk, e.g. copies named like "file (1).jpg".
+	// Junk files are deleted if their group has a file that isn't junk, which is also the only one kept by the
+	// positional rules. Groups of junk files only are handled by the other rules.
+	JunkMatch *regexp.Regexp
+	// MatchTarget is the form of the path the patterns of DelMatch, KeepMatch, JunkMatch and KeepPriority are matched
+	// against, MatchWalked (default), MatchAbsolute or MatchRelative
+	MatchTarget string
+	// ReferencePaths are indexed, but files found there are never deleted.
+	// Their duplicates in other paths are deleted instead.
+	ReferencePaths []string
+	// ReferenceDBs are databases, local or remote, whose hashed files are merged as reference files.
+	// They are only read, the database at Path is the only one written.
+	ReferenceDBs []string
+	// DeleteAllowedRoots restricts deletion to files below these paths, if set
+	DeleteAllowedRoots []string
+	// KeepSelector chooses the file to keep of each duplicate group, overriding the rules keeping a single file.
+	// It returns the index of the file in the group, which is sorted by path, or -1 to keep all files.
+	// Errors abort deletion. It's called for one group at a time, before anything of the group is deleted.
+	KeepSelector func(files file.Slice) (int, error)
+	Workers      int
+	// QueueDepth is the number of files queued for hashing, 0 defaults to 4 per worker, negative means unbuffered
+	QueueDepth int
+	// HashOrder defines in which order files are hashed
+	HashOrder string
+	// HashAll hashes every indexed file, not only files sharing their size with another one, e.g. to compare databases.
+	// Normalized content is always hashed completely, as its duplicates can differ in size.
+	HashAll bool
+	// MaxReadBytesPerSec limits the bytes read for hashing by all workers together, 0 means unlimited.
+	// Output of normalize commands and fingerprinters reading files themselves isn't limited.
+	MaxReadBytesPerSec int64
+	// MaxOpenFiles bounds the files opened concurrently for hashing, independent of the workers.
+	// 0 uses half the soft limit of open file descriptors, negative values disable the bound.
+	MaxOpenFiles int
+	// HashRetries is the number of times hashing a file is retried after transient errors, e.g. timeouts of network
+	// filesystems. The first retry waits HashRetryDelay, which doubles for every further retry.
+	HashRetries    int
+	HashRetryDelay time.Duration
+	// HashAlgorithm is xxhash (default) or blake3
+	HashAlgorithm string
+	// HashKey keys blake3 hashes, it must be 32 bytes long. Hashes with different keys are never compared,
+	// so they can't be correlated without the key.
+	HashKey    []byte
+	SkipHidden bool
+	// HashManifest is a file of known hashes of the configured algorithm, one "hash  path" pair per line as written by
+	// xxhsum or b3sum. Files not modified after the manifest are taken over without hashing. They are hashed before
+	// they are acted on as duplicates, to verify the manifest.
+	HashManifest string
+	// ReferenceHashes is a file of known hashes of the configured algorithm and sizes, one "hash size" pair per line.
+	// They are compared like reference files, without the files being present, so matching files are deleted.
+	// Hard linking skips them, as there's no local file to link to.
+	ReferenceHashes string
+	// DetectMoves reuses the hash of a file that vanished since the last run for a new file with the same inode, size,
+	// mtime and hash of the first bytes, instead of hashing the new file completely. Both runs must have it enabled.
+	DetectMoves bool
+	// MaxAge skips files whose mtime is older than this duration before the start, 0 means no limit
+	MaxAge time.Duration
+	// ExcludeFile is a file of paths to skip while indexing, one per line. Entries are path prefixes or glob patterns,
+	// patterns without a path separator match file names. Blank lines and lines starting with # are ignored.
+	ExcludeFile string
+	// IncludeModes are the device types indexed besides regular files, os.ModeDevice includes block devices and
+	// os.ModeCharDevice character devices.
+	// The size of devices is determined by seeking to their end, devices of unknown size are skipped.
+	// Devices are never deleted, only their duplicates.
+	IncludeModes os.FileMode
+	// IndexCheckpoint writes the database periodically while indexing, so an interrupted run resumes indexing
+	// with the files recorded so far. Requires a database.
+	IndexCheckpoint bool
+	// IncrementalWalk skips reading directories whose mtime didn't change since the last run, requires a database.
+	// Files within are known from the database and checked for changes individually.
+	IncrementalWalk bool
+	// RunID identifies the run, it's recorded on every file found while indexing as the last run that saw it.
+	// Files of earlier runs weren't found since, e.g. candidates for pruning. Empty records nothing.
+	RunID string
+	// IgnoreSizes skips files of exactly these sizes in bytes
+	IgnoreSizes []int64
+	PreCount    bool
+	// IncludeEmpty indexes empty files, which are skipped otherwise
+	IncludeEmpty bool
+	// EmptyDistinct reports empty files, but never deletes them as duplicates of each other, e.g. marker or lock files.
+	// Requires IncludeEmpty.
+	EmptyDistinct bool
+	// StrictRoots fails indexing if any given path doesn't exist
+	StrictRoots bool
+	// DedupByInode handles the same file (device and inode) found under different paths,
+	// e.g. with overlapping roots. Empty treats every path as a separate file.
+	DedupByInode string
+	OnProgress   func(Progress)
+	// OnBytes is called while hashing large files with the bytes read so far, at most once every few MiB.
+	// Once the read ends, it's called with the final count. Calls are serialized with OnProgress.
+	OnBytes func(path string, done, total int64)
+	// OnBeforeDelete is called for every file about to be deleted, after all rules matched.
+	// Returning false keeps the file. It's only called when actually deleting, not on dry runs.
+	// The survivor is nil if no local file is kept, e.g. for duplicates of ReferenceHashes.
+	OnBeforeDelete func(fil *file.File, survivor *file.File) bool
+
+	PruneEmptyDirs bool
+	// ScriptPath writes a shell script with the removals of a dry run, to be reviewed and run manually
+	ScriptPath string
+	// DeleteWorkers removes duplicates in parallel if greater than 1, e.g. for network filesystems
+	DeleteWorkers int
+	// MaxDeleteBytes stops deleting once the freed bytes would exceed it, 0 means unlimited.
+	// Files with other hard links don't free any space.
+	MaxDeleteBytes int64
+	// MinTotalDuplicates deletes nothing if fewer redundant copies were found in total, 0 disables the check.
+	// Guards against misconfigured runs that find almost nothing.
+	MinTotalDuplicates int
+	// ConfirmOnce prints a summary of all deletions and asks once for confirmation before deleting anything.
+	// Without confirmation, the run continues as a dry run.
+	ConfirmOnce bool
+	// ConfirmInput is read for the answer, defaults to stdin
+	ConfirmInput io.Reader
+	// ConfirmOutput receives the summary and prompt, defaults to stderr
+	ConfirmOutput io.Writer
+
+	// SameName only considers duplicates with the same file name, extensions are compared case insensitively
+	SameName bool
+	// ExtAliases maps extensions to the extension they are equivalent to when comparing names, e.g. jpeg to jpg.
+	// Extensions may be given with or without leading dot.
+	ExtAliases map[string]string
+
+	// TimeWindow only considers duplicates whose modification times are close to each other.
+	// Files are grouped into clusters in which each file is within the window of another one, 0 disables it.
+	TimeWindow time.Duration
+	// StrictTimeWindow groups files of a time window without chaining them, all files of a cluster are within the
+	// window of its oldest one and thus of each other.
+	StrictTimeWindow bool
+
+	// ScanArchives indexes the entries of zip and tar archives, named like archive.zip!/entry.
+	// Entries can't be deleted, so deletion is refused in this mode.
+	ScanArchives bool
+
+	// KeepPartialHashes records the hash of the content read before a read error instead of dropping the file.
+	// Partial hashes are never used to find duplicates.
+	KeepPartialHashes bool
+
+	// SampleFraction only hashes this fraction of the groups of equally sized files, chosen at random, and reports
+	// an estimate of the duplicates extrapolated from them. 0 disables sampling, deletion is refused with sampling.
+	SampleFraction float64
+
+	// DetectMime stores the content type of hashed files
+	DetectMime bool
+	// MimeFilter only considers duplicates whose content type matches the pattern, e.g. image/*
+	MimeFilter string
+	// HashShard only handles the duplicate groups of one shard of the hashes, given as "index/count", e.g. 0/4.
+	// Machines running the other shards handle the remaining groups. Empty handles all groups.
+	HashShard string
+
+	// PrefixOnly only hashes the first PrefixBytes of each file.
+	// This is fast but approximate, so deletion is refused in this mode.
+	PrefixOnly  bool
+	PrefixBytes int64
+
+	// NormalizeCmd is run for every file, with {} replaced by the path, and its output is hashed instead of the content.
+	// Files are compared by domain specific equality this way, so deletion is refused in this mode.
+	NormalizeCmd string
+	// NormalizeText hashes text files with uniform line endings and without trailing whitespace, so files differing
+	// only in those are duplicates. Binary files are hashed as they are. Deletion is refused in this mode.
+	NormalizeText bool
+	// Fingerprinter returns a domain specific fingerprint of the file at path, e.g. an acoustic fingerprint of a song,
+	// which is hashed instead of the content. Like with NormalizeCmd, deletion is refused in this mode.
+	// Fingerprints of different fingerprinters are indistinguishable in the database, use a separate one for each.
+	Fingerprinter func(path string) (string, error)
+
+	// LinkMode defines how duplicates are removed
+	LinkMode string
+	// LinkSameDevOnly only hardlinks duplicates on the same device as the kept file,
+	// others are skipped or deleted if LinkFallbackDelete is set
+	LinkSameDevOnly    bool
+	LinkFallbackDelete bool
+
+	// FS is used for indexing and hashing, defaults to the OS filesystem.
+	// Deletion always acts on the OS filesystem.
+	FS fs.FS
+
+	// Output receives all messages, defaults to stdout
+	Output       io.Writer
+	OutputFormat string
+	// Verbosity defines which messages are printed, VerbosityNormal if unset
+	Verbosity Verbosity
+	// Verbose raises Verbosity to VerbosityVerbose if set.
+	//
+	// Deprecated: use Verbosity instead.
+	Verbose bool
+	// HashDisplayLen truncates hashes in the text report to this many hex digits, 0 prints them in full
+	HashDisplayLen int
+	// ReportActedOnly omits groups from the text report whose files are all kept by the rules
+	ReportActedOnly bool
+}
+
+// ParseShard parses a shard given as "index/count", with the index from 0 to count-1
+func ParseShard(shard string) (int, int, error) {
+	index, count, ok := strings.Cut(shard, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid shard '%s', expected index/count", shard)
+	}
+	i, err := strconv.Atoi(index)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid shard index '%s': %w", index, err)
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid shard count '%s': %w", count, err)
+	}
+	if n < 1 || i < 0 || i >= n {
+		return 0, 0, fmt.Errorf("invalid shard '%s', expected an index from 0 to count-1", shard)
+	}
+	return i, n, nil
 }
@@ -0,0 +1,248 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lixmal/finddupes/pkg/trash"
+)
+
+var (
+	ErrApproximate = errors.New("refusing to delete based on approximate hashes")
+	ErrNoRule      = errors.New("delete given without a rule choosing the files to keep or delete")
+)
+
+// Validate checks the configuration for invalid values
+func (c Config) Validate() error {
+	if _, err := filepath.Match(c.MimeFilter, ""); err != nil {
+		return fmt.Errorf("invalid mime filter '%s': %w", c.MimeFilter, err)
+	}
+
+	if c.PrefixOnly {
+		if c.PrefixBytes <= 0 {
+			return fmt.Errorf("prefix bytes must be positive, got %d", c.PrefixBytes)
+		}
+		if c.Delete {
+			return fmt.Errorf("prefix only: %w", ErrApproximate)
+		}
+	}
+
+	if c.NormalizeCmd != "" {
+		if strings.TrimSpace(c.NormalizeCmd) == "" {
+			return errors.New("normalize command is empty")
+		}
+		if c.PrefixOnly {
+			return errors.New("normalize command can't be combined with prefix only hashing")
+		}
+		if c.Delete {
+			return fmt.Errorf("normalize command: %w", ErrApproximate)
+		}
+	}
+
+	if c.Fingerprinter != nil {
+		if c.NormalizeCmd != "" || c.PrefixOnly {
+			return errors.New("a fingerprinter can't be combined with a normalize command or prefix only hashing")
+		}
+		if c.Delete {
+			return fmt.Errorf("fingerprinter: %w", ErrApproximate)
+		}
+	}
+
+	if c.NormalizeText {
+		if c.NormalizeCmd != "" || c.Fingerprinter != nil || c.PrefixOnly {
+			return errors.New("normalizing text can't be combined with a normalize command, fingerprinter or prefix only hashing")
+		}
+		if c.Delete {
+			return fmt.Errorf("normalize text: %w", ErrApproximate)
+		}
+	}
+
+	if c.Workers < 1 {
+		return fmt.Errorf("at least one worker is required, got %d", c.Workers)
+	}
+	if c.MinTotalDuplicates < 0 {
+		return fmt.Errorf("minimum total duplicates must not be negative, got %d", c.MinTotalDuplicates)
+	}
+	if c.HashDisplayLen < 0 {
+		return fmt.Errorf("hash display length must not be negative, got %d", c.HashDisplayLen)
+	}
+	if c.MaxReadBytesPerSec < 0 {
+		return fmt.Errorf("read rate must not be negative, got %d", c.MaxReadBytesPerSec)
+	}
+	if c.HashRetries < 0 || c.HashRetryDelay < 0 {
+		return errors.New("hash retries and their delay must not be negative")
+	}
+
+	if (c.KeepLargestFile || c.KeepSmallestFile) && c.NormalizeCmd == "" && c.Fingerprinter == nil && !c.NormalizeText {
+		return errors.New("keeping files by size requires normalized content, duplicates are of equal size otherwise")
+	}
+
+	if c.ScanArchives && c.Delete {
+		return errors.New("scanning archives is report only, refusing to delete")
+	}
+
+	if c.SampleFraction < 0 || c.SampleFraction > 1 {
+		return fmt.Errorf("sample fraction must be between 0 and 1, got %g", c.SampleFraction)
+	}
+	if c.SampleFraction > 0 && c.Delete {
+		return errors.New("sampling only estimates duplicates, refusing to delete")
+	}
+	if c.SampleFraction > 0 && (c.NormalizeCmd != "" || c.Fingerprinter != nil || c.NormalizeText) {
+		return errors.New("sampling groups files by size, normalized content can't be sampled")
+	}
+
+	if c.HashShard != "" {
+		if _, _, err := ParseShard(c.HashShard); err != nil {
+			return err
+		}
+	}
+
+	if c.ScriptPath != "" && c.Delete {
+		return errors.New("a script is only written in dry runs, can't be combined with delete")
+	}
+
+	if c.ConfirmOnce && c.KeepSelector != nil {
+		return errors.New("confirming once can't be combined with a keep selector, files are chosen per group")
+	}
+
+	if c.Delete && !c.hasRule() {
+		return ErrNoRule
+	}
+
+	if c.StoreOnly && (strings.HasPrefix(c.Path, "http://") || strings.HasPrefix(c.Path, "https://")) {
+		return errors.New("remote databases are read only, can't store hashes")
+	}
+
+	if c.IncludeModes&^(os.ModeDevice|os.ModeCharDevice) != 0 {
+		return fmt.Errorf("only device modes can be included, got %s", c.IncludeModes&^(os.ModeDevice|os.ModeCharDevice))
+	}
+
+	if c.EmptyDistinct && !c.IncludeEmpty {
+		return errors.New("keeping empty files distinct requires including empty files")
+	}
+
+	if c.MaxAge < 0 {
+		return fmt.Errorf("max age must not be negative, got %s", c.MaxAge)
+	}
+
+	if c.IncrementalWalk && c.Path == "" {
+		return errors.New("incremental walks require a database path")
+	}
+	if c.IndexCheckpoint {
+		if c.Path == "" || strings.HasPrefix(c.Path, "http://") || strings.HasPrefix(c.Path, "https://") {
+			return errors.New("index checkpoints require a local database path")
+		}
+		// directories are recorded before their files are indexed, a checkpoint in between would skip them next time
+		if c.IncrementalWalk {
+			return errors.New("index checkpoints can't be combined with incremental walks")
+		}
+	}
+
+	switch c.OnlyStage {
+	case "":
+	case StageIndex, StageHash, StageDelete:
+		if c.Path == "" || strings.HasPrefix(c.Path, "http://") || strings.HasPrefix(c.Path, "https://") {
+			return fmt.Errorf("running the %s stage alone requires a local database path", c.OnlyStage)
+		}
+		if c.SampleFraction > 0 {
+			return errors.New("sampling can't be combined with running a single stage")
+		}
+	default:
+		return fmt.Errorf("unknown stage '%s'", c.OnlyStage)
+	}
+
+	if c.TempDir != "" {
+		if info, err := os.Stat(c.TempDir); err != nil {
+			return fmt.Errorf("temp dir: %w", err)
+		} else if !info.IsDir() {
+			return fmt.Errorf("temp dir '%s' is not a directory", c.TempDir)
+		}
+	}
+
+	switch c.MatchTarget {
+	case "", MatchAbsolute, MatchRelative, MatchWalked:
+	default:
+		return fmt.Errorf("unknown match target '%s'", c.MatchTarget)
+	}
+
+	switch c.LinkMode {
+	case "", LinkModeDelete, LinkModeHardlink, LinkModeStub:
+	case LinkModeTrash:
+		if !trash.Supported {
+			return fmt.Errorf("link mode '%s': %w", c.LinkMode, trash.ErrUnsupported)
+		}
+	default:
+		return fmt.Errorf("unknown link mode '%s'", c.LinkMode)
+	}
+
+	switch c.DBFormat {
+	case "", "gob", "json":
+	default:
+		return fmt.Errorf("unknown database format '%s'", c.DBFormat)
+	}
+
+	switch c.DedupByInode {
+	case "", DedupByInodeSkip, DedupByInodeAlias:
+	default:
+		return fmt.Errorf("unknown inode deduplication mode '%s'", c.DedupByInode)
+	}
+
+	switch c.HashAlgorithm {
+	case "", HashAlgorithmXXHash, HashAlgorithmBlake3:
+	default:
+		return fmt.Errorf("unknown hash algorithm '%s'", c.HashAlgorithm)
+	}
+	if len(c.HashKey) > 0 {
+		if c.HashAlgorithm != HashAlgorithmBlake3 {
+			return errors.New("a hash key requires the blake3 algorithm")
+		}
+		if len(c.HashKey) != HashKeyLen {
+			return fmt.Errorf("hash key must be %d bytes long, got %d", HashKeyLen, len(c.HashKey))
+		}
+	}
+
+	if c.HashManifest != "" && (c.NormalizeCmd != "" || c.Fingerprinter != nil || c.NormalizeText || c.PrefixOnly) {
+		return errors.New("a hash manifest lists content hashes, can't be combined with normalized or prefix only hashing")
+	}
+	if c.ReferenceHashes != "" && (c.NormalizeCmd != "" || c.Fingerprinter != nil || c.NormalizeText || c.PrefixOnly) {
+		return errors.New("reference hashes are content hashes, can't be combined with normalized or prefix only hashing")
+	}
+
+	switch c.HashOrder {
+	case "", HashOrderNone, HashOrderLargestFirst, HashOrderSmallestFirst:
+	default:
+		return fmt.Errorf("unknown hash order '%s'", c.HashOrder)
+	}
+
+	return nil
+}
+
+// hasRule reports whether any rule selects files to keep or delete.
+// Without one, nothing would be deleted.
+func (c Config) hasRule() bool {
+	return c.DelMatch != nil || c.KeepMatch != nil || c.JunkMatch != nil ||
+		c.KeepFirst || c.KeepLast || c.KeepOldest || c.KeepRecent || c.KeepRecentAccess || c.KeepOldestAccess ||
+		c.KeepLargestFile || c.KeepSmallestFile || c.KeepMostRestrictive || c.KeepLeastRestrictive ||
+		len(c.KeepPriority) > 0 || c.KeepUser != "" || c.KeepGroup != "" || c.KeepTagged ||
+		len(c.ReferencePaths) > 0 || len(c.ReferenceDBs) > 0 || c.ReferenceHashes != "" || c.KeepSelector != nil ||
+		// all files of remote databases are reference files
+		strings.HasPrefix(c.Path, "http://") || strings.HasPrefix(c.Path, "https://")
+}
+
+// SurvivorRules returns the number of rules choosing the single file to keep.
+// Only one of them is applied, by a fixed precedence.
+func (c Config) SurvivorRules() int {
+	n := 0
+	for _, set := range []bool{
+		c.KeepFirst, c.KeepLast, c.KeepOldest, c.KeepRecent, c.KeepRecentAccess, c.KeepOldestAccess,
+		c.KeepLargestFile, c.KeepSmallestFile, c.KeepMostRestrictive, c.KeepLeastRestrictive, len(c.KeepPriority) > 0,
+	} {
+		if set {
+			n++
+		}
+	}
+	return n
+}
@@ -1,66 +1,378 @@
 package database
 
 import (
+	"crypto/sha256"
 	"encoding/gob"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/lixmal/finddupes/pkg/file"
-	"github.com/lixmal/finddupes/pkg/misc"
+	"github.com/lixmal/finddupes/pkg/version"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	FormatGob  = "gob"
+	FormatJSON = "json"
+)
+
+var (
+	ErrIsDirectory   = errors.New("database path is a directory")
+	ErrParentMissing = errors.New("parent directory of database path does not exist")
+	ErrUnknownFormat = errors.New("unknown database format")
+	ErrSymlink       = errors.New("database path is a symlink")
 )
 
 type Database struct {
-	Files  map[int64]file.Map
-	Hashes map[string]file.Map
-	mutex  sync.Mutex
+	// Version of finddupes that wrote the database, empty for databases written before versions were recorded
+	Version string
+	Files   map[int64]file.Map
+	Hashes  map[string]file.Map
+	// Dirs are the directories walked incrementally, by path
+	Dirs  map[string]Dir
+	mutex sync.Mutex
+	// dirty is set once the tables were modified after reading or writing, accessed atomically
+	dirty int32
+}
+
+// Dir is the state of a directory when it was last walked
+type Dir struct {
+	MTime time.Time
+	// Subdirs are the paths of the directories within
+	Subdirs []string
 }
 
 func New() *Database {
 	return &Database{
 		Files:  map[int64]file.Map{},
 		Hashes: map[string]file.Map{},
+		Dirs:   map[string]Dir{},
 		mutex:  sync.Mutex{},
 	}
 }
 
-func (d *Database) Write(path string) error {
-	file, err := os.Create(path)
+// Write stores the database at path.
+// It's written to a temporary file next to it first and renamed once complete, an interrupted write leaves
+// the previous database intact. If path is a symlink, its target is written and the link kept.
+func (d *Database) Write(path string, format string) error {
+	return d.WriteTemp(path, format, "")
+}
+
+// WriteTemp stores the database at path like Write, with the temporary file written in tempDir, if not empty.
+// If tempDir is on another filesystem, the complete file is copied next to path and renamed from there,
+// so the write stays atomic.
+func (d *Database) WriteTemp(path string, format string, tempDir string) error {
+	if IsRemote(path) {
+		return fmt.Errorf("write database: %w", ErrRemoteReadOnly)
+	}
+
+	path, err := ResolveLink(path)
 	if err != nil {
 		return fmt.Errorf("write database: %w", err)
 	}
+
+	if tempDir == "" {
+		tempDir = filepath.Dir(path)
+	}
+	file, err := os.CreateTemp(tempDir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("write database: %w", err)
+	}
+	defer os.Remove(file.Name())
 	defer file.Close()
 
-	if err := gob.NewEncoder(file).Encode(d); err != nil {
+	// keep the permissions of the previous database, temporary files are only accessible by the owner
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := file.Chmod(mode); err != nil {
 		return fmt.Errorf("write database: %w", err)
 	}
 
+	d.Version = version.Get()
+	if err := d.encode(file, format); err != nil {
+		return fmt.Errorf("write database: %w", err)
+	}
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("write database: %w", err)
+	}
 	// explicit close to catch any errors writing
 	if err = file.Close(); err != nil {
 		return fmt.Errorf("write database: %w", err)
 	}
+	err = os.Rename(file.Name(), path)
+	if errors.Is(err, syscall.EXDEV) {
+		err = copyRename(file.Name(), path, mode)
+	}
+	if err != nil {
+		return fmt.Errorf("write database: %w", err)
+	}
+	atomic.StoreInt32(&d.dirty, 0)
 
 	return nil
 }
 
-func (d *Database) Read(path string) error {
-	file, err := os.Open(path)
+// copyRename copies src to a temporary file next to dst and renames it to dst, for files on different filesystems
+func copyRename(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	if err := out.Chmod(mode); err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), dst)
+}
+
+// Read replaces the tables with the database stored at path, which may also be an HTTP(S) URL.
+// The tables are only replaced once the whole database was decoded, they are left untouched on any error.
+func (d *Database) Read(path string, format string) error {
+	var f io.ReadCloser
+	var err error
+	if IsRemote(path) {
+		f, err = openRemote(path)
+	} else {
+		f, err = os.Open(path)
+	}
 	if err != nil {
 		return fmt.Errorf("read database: %w", err)
 	}
-	defer misc.Close(path, file)
 
-	// TODO: fix reading db from interface
-	var db Database
-	if err := gob.NewDecoder(file).Decode(&db); err != nil {
+	db, err := decode(f, format)
+	// decoding errors take precedence, close errors are only relevant for a complete read
+	closeErr := f.Close()
+	if err != nil {
 		return fmt.Errorf("read database: %w", err)
 	}
+	if closeErr != nil {
+		return fmt.Errorf("read database: %w", closeErr)
+	}
 
-	*d = db
+	// gob omits empty maps
+	if db.Files == nil {
+		db.Files = map[int64]file.Map{}
+	}
+	if db.Hashes == nil {
+		db.Hashes = map[string]file.Map{}
+	}
+	if db.Dirs == nil {
+		db.Dirs = map[string]Dir{}
+	}
+
+	d.Version = db.Version
+	d.Files = db.Files
+	d.Hashes = db.Hashes
+	d.Dirs = db.Dirs
+	atomic.StoreInt32(&d.dirty, 0)
 
 	return nil
 }
 
+func (d *Database) encode(w io.Writer, format string) error {
+	switch format {
+	case "", FormatGob:
+		return gob.NewEncoder(w).Encode(d)
+	case FormatJSON:
+		return d.encodeJSON(w)
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+}
+
+func decode(r io.Reader, format string) (*Database, error) {
+	switch format {
+	case "", FormatGob:
+		// TODO: fix reading db from interface
+		var db Database
+		if err := gob.NewDecoder(r).Decode(&db); err != nil {
+			return nil, err
+		}
+		db.relinkHashes()
+		return &db, nil
+	case FormatJSON:
+		return decodeJSON(r)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+}
+
+// relinkHashes rebuilds the hashes table from the files table.
+// gob doesn't keep pointers shared, the tables would hold separate copies of each file otherwise.
+func (d *Database) relinkHashes() {
+	d.Hashes = map[string]file.Map{}
+	for _, files := range d.Files {
+		for _, fil := range files {
+			if fil.Hash != "" && !fil.Partial {
+				d.AddHash(fil)
+			}
+		}
+	}
+}
+
+// ResolveLink returns the path a database at path is written to, the final target if path is a symlink.
+// Targets don't need to exist.
+func ResolveLink(path string) (string, error) {
+	// same limit as Linux
+	for i := 0; i < 40; i++ {
+		info, err := os.Lstat(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			return path, nil
+		case err != nil:
+			return "", err
+		case info.Mode()&os.ModeSymlink == 0:
+			return path, nil
+		}
+
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		path = target
+	}
+
+	return "", &os.PathError{Op: "resolve", Path: path, Err: syscall.ELOOP}
+}
+
+// IsSymlink reports whether path is a symlink
+func IsSymlink(path string) bool {
+	info, err := os.Lstat(path)
+	return err == nil && info.Mode()&os.ModeSymlink != 0
+}
+
+// CheckWritable verifies that a database can be written to path, without modifying anything.
+// Symlinks are resolved, their target is written.
+func CheckWritable(path string) error {
+	path, err := ResolveLink(path)
+	if err != nil {
+		return fmt.Errorf("check database path: %w", err)
+	}
+
+	info, err := os.Stat(path)
+	switch {
+	case err == nil && info.IsDir():
+		return fmt.Errorf("check database path '%s': %w", path, ErrIsDirectory)
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("check database path '%s': %w", path, err)
+	}
+
+	// the database is written to a temporary file in the parent directory and renamed
+	dir := filepath.Dir(path)
+	info, err = os.Stat(dir)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("check database path '%s': %w", path, ErrParentMissing)
+	case err != nil:
+		return fmt.Errorf("check database path '%s': %w", path, err)
+	case !info.IsDir():
+		return fmt.Errorf("check database path '%s': %w", path, &os.PathError{Op: "stat", Path: dir, Err: syscall.ENOTDIR})
+	}
+
+	if err := unix.Access(dir, unix.W_OK|unix.X_OK); err != nil {
+		return fmt.Errorf("check database path '%s': %w", path, &os.PathError{Op: "access", Path: dir, Err: err})
+	}
+
+	return nil
+}
+
+// MarkDirty records a modification of the tables or the files within, made without the methods below
+func (d *Database) MarkDirty() {
+	atomic.StoreInt32(&d.dirty, 1)
+}
+
+// Dirty reports whether the tables were modified since the database was read or written
+func (d *Database) Dirty() bool {
+	return atomic.LoadInt32(&d.dirty) != 0
+}
+
+// Add adds the file to the files table
+func (d *Database) Add(fil *file.File) {
+	d.MarkDirty()
+	if d.Files[fil.Size] == nil {
+		d.Files[fil.Size] = file.Map{}
+	}
+	d.Files[fil.Size][fil.Path] = fil
+}
+
+// AddHash adds the hashed file to the hashes table
+func (d *Database) AddHash(fil *file.File) {
+	d.MarkDirty()
+	key := fil.HashKey()
+	if d.Hashes[key] == nil {
+		d.Hashes[key] = file.Map{}
+	}
+	d.Hashes[key][fil.Path] = fil
+}
+
+// Remove removes the file from all tables.
+// It must be called before changing the file's size or hash.
+func (d *Database) Remove(fil *file.File) {
+	d.MarkDirty()
+	if d.Files[fil.Size] != nil {
+		delete(d.Files[fil.Size], fil.Path)
+	}
+	if fil.Hash != "" {
+		delete(d.Hashes[fil.HashKey()], fil.Path)
+	}
+}
+
+// SetHash returns a fingerprint of the hashed files, in hex, to compare databases with a single value.
+// It covers the paths and hashes of all files, or only the distinct content hashes if contentOnly is set.
+// The result doesn't depend on the order files were added in.
+func (d *Database) SetHash(contentOnly bool) string {
+	var entries []string
+	for key, files := range d.Hashes {
+		if len(files) == 0 {
+			continue
+		}
+		if contentOnly {
+			entries = append(entries, key)
+			continue
+		}
+		for path := range files {
+			entries = append(entries, path+"\x00"+key)
+		}
+	}
+	sort.Strings(entries)
+
+	h := sha256.New()
+	for _, entry := range entries {
+		// the length prefix keeps paths containing newlines unambiguous
+		fmt.Fprintf(h, "%d:%s\n", len(entry), entry)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 func (d *Database) Lock() {
 	d.mutex.Lock()
 }
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/lixmal/finddupes/pkg/file"
+)
+
+// jsonDatabase is the JSON representation of the database.
+// The tables are rebuilt from the file list when reading, as binary hashes can't be used as JSON keys.
+type jsonDatabase struct {
+	Version string         `json:"version"`
+	Files   []*file.File   `json:"files"`
+	Dirs    map[string]Dir `json:"dirs,omitempty"`
+}
+
+func (d *Database) encodeJSON(w io.Writer) error {
+	db := jsonDatabase{Version: d.Version, Dirs: d.Dirs}
+	for _, files := range d.Files {
+		db.Files = append(db.Files, files.ToSlice()...)
+	}
+	// stable output, to be diffable
+	db.Files = file.Slice(db.Files).SortByPath()
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(db)
+}
+
+func decodeJSON(r io.Reader) (*Database, error) {
+	var db jsonDatabase
+	if err := json.NewDecoder(r).Decode(&db); err != nil {
+		return nil, err
+	}
+
+	d := New()
+	d.Version = db.Version
+	if db.Dirs != nil {
+		d.Dirs = db.Dirs
+	}
+	for _, fil := range db.Files {
+		d.Add(fil)
+		if fil.Hash != "" && !fil.Partial {
+			d.AddHash(fil)
+		}
+	}
+
+	return d, nil
+}
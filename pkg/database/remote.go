@@ -0,0 +1,42 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrRemoteReadOnly = errors.New("remote databases are read only")
+	ErrRemoteStatus   = errors.New("unexpected response status")
+	ErrRemoteType     = errors.New("unexpected content type")
+)
+
+// IsRemote reports whether the database path is an HTTP(S) URL
+func IsRemote(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// openRemote requests the database from the URL and returns the response body
+func openRemote(url string) (io.ReadCloser, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s", ErrRemoteStatus, resp.Status)
+	}
+
+	// e.g. an error page of a proxy, which would fail decoding with a confusing error
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil && strings.HasPrefix(mediaType, "text/html") {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s", ErrRemoteType, mediaType)
+	}
+
+	return resp.Body, nil
+}
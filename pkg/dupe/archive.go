@@ -0,0 +1,271 @@
+package dupe
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lixmal/finddupes/pkg/file"
+	"github.com/lixmal/finddupes/pkg/misc"
+)
+
+// archiveSep separates the archive path from the path of an entry inside it
+const archiveSep = "!/"
+
+var errEntryNotFound = errors.New("archive entry not found")
+
+// isArchive reports whether the path has the extension of a supported archive
+func isArchive(path string) bool {
+	return isZip(path) || isTar(path)
+}
+
+func isZip(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), ".zip")
+}
+
+func isTar(path string) bool {
+	lower := strings.ToLower(path)
+	return strings.HasSuffix(lower, ".tar") || isTarGz(path)
+}
+
+func isTarGz(path string) bool {
+	lower := strings.ToLower(path)
+	return strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz")
+}
+
+// indexArchive adds the regular, non-empty entries of the archive to the database
+func (d *Dupe) indexArchive(path string) error {
+	return d.walkArchive(path, func(name string, info fs.FileInfo, _ io.Reader) (bool, error) {
+		entryPath := path + archiveSep + name
+		if _, exists := d.paths[entryPath]; exists || !info.Mode().IsRegular() || info.Size() == 0 || d.ignoredSize(info.Size()) {
+			return false, nil
+		}
+
+		if d.verbose() {
+			d.logf("Processing archive entry %s\n", entryPath)
+		}
+
+		fil := &file.File{
+			Path:      entryPath,
+			Size:      info.Size(),
+			MTime:     info.ModTime(),
+			Mode:      info.Mode(),
+			Root:      d.root,
+			Archive:   path,
+			Reference: d.reference,
+		}
+		d.database.Add(fil)
+		d.paths[entryPath] = fil
+		d.added++
+
+		return false, nil
+	})
+}
+
+// openEntry returns the content of the archive entry, streamed from the archive while it's read.
+// Errors reading the archive are returned by Read. Hashing reads all entries of an archive in a single pass instead,
+// see hashArchive.
+func (d *Dupe) openEntry(fil *file.File) (io.ReadCloser, error) {
+	name := entryName(fil)
+
+	pr, pw := io.Pipe()
+	go func() {
+		found := false
+		err := d.walkArchive(fil.Archive, func(entry string, _ fs.FileInfo, r io.Reader) (bool, error) {
+			if entry != name {
+				return false, nil
+			}
+
+			found = true
+			// fails once the reader is closed
+			_, err := io.Copy(pw, r)
+			return true, err
+		})
+		if err == nil && !found {
+			err = fmt.Errorf("open '%s': %w", fil.Path, errEntryNotFound)
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return pr, nil
+}
+
+// entryName returns the path of the archive entry inside its archive
+func entryName(fil *file.File) string {
+	return strings.TrimPrefix(fil.Path, fil.Archive+archiveSep)
+}
+
+// hashJob is a file to hash, or the entries of an archive to hash in a single pass over it
+type hashJob struct {
+	file    *file.File
+	entries file.Slice
+}
+
+// hashJobs groups the entries of each archive into a single job, in the order of their first entry.
+// Entries hashed by a command or fingerprinter are hashed one by one, those read the path on their own.
+func (d *Dupe) hashJobs(candidates file.Slice) []hashJob {
+	jobs := make([]hashJob, 0, len(candidates))
+	archives := map[string]int{}
+	for _, fil := range candidates {
+		if fil.Archive == "" || d.config.NormalizeCmd != "" || d.config.Fingerprinter != nil {
+			jobs = append(jobs, hashJob{file: fil})
+			continue
+		}
+
+		if i, ok := archives[fil.Archive]; ok {
+			jobs[i].entries = append(jobs[i].entries, fil)
+			continue
+		}
+		archives[fil.Archive] = len(jobs)
+		jobs = append(jobs, hashJob{entries: file.Slice{fil}})
+	}
+
+	return jobs
+}
+
+// hashArchive hashes the entries of an archive on the given worker, streaming them in a single pass over the archive.
+// Entries that aren't found are recorded as errors.
+func (d *Dupe) hashArchive(worker int, entries file.Slice) {
+	archive := entries[0].Archive
+
+	pending := make(map[string]*file.File, len(entries))
+	for _, fil := range entries {
+		if !d.needsHash(fil) {
+			d.hashProgress(fil.Size)
+			continue
+		}
+		pending[entryName(fil)] = fil
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	if !d.acquireFile() {
+		return
+	}
+	defer d.releaseFile()
+
+	err := d.walkArchive(archive, func(name string, _ fs.FileInfo, r io.Reader) (bool, error) {
+		fil, ok := pending[name]
+		if !ok {
+			return false, nil
+		}
+		delete(pending, name)
+
+		start := time.Now()
+		if d.verbose() {
+			d.logf("  Calculating hash for %s\n", fil.Path)
+		}
+		hash, err := d.hashContent(fil, r)
+		d.countHash(worker, fil, start)
+		d.hashProgress(fil.Size)
+		d.collectHash(worker, fil, hash, err)
+
+		if d.ctx.Err() != nil {
+			return true, ErrProcessStopped
+		}
+		return len(pending) == 0, nil
+	})
+	if errors.Is(err, ErrProcessStopped) {
+		return
+	}
+
+	for _, fil := range pending {
+		d.hashProgress(fil.Size)
+		entryErr := err
+		if entryErr == nil {
+			entryErr = fmt.Errorf("open '%s': %w", fil.Path, errEntryNotFound)
+		}
+		log.Println(entryErr)
+		d.addError(&HashError{Path: fil.Path, Err: entryErr})
+	}
+}
+
+// walkArchive calls fn for every entry of the archive, until fn returns true or an error.
+// The reader is only valid during the call.
+func (d *Dupe) walkArchive(path string, fn func(name string, info fs.FileInfo, r io.Reader) (bool, error)) error {
+	f, err := d.fs.Open(path)
+	if err != nil {
+		return fmt.Errorf("read archive: %w", err)
+	}
+	defer misc.Close(path, f)
+
+	if isZip(path) {
+		return walkZip(f, fn)
+	}
+
+	var r io.Reader = f
+	if isTarGz(path) {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("read archive '%s': %w", path, err)
+		}
+		defer misc.Close(path, gz)
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read archive '%s': %w", path, err)
+		}
+
+		done, err := fn(hdr.Name, hdr.FileInfo(), tr)
+		if done || err != nil {
+			return err
+		}
+	}
+}
+
+func walkZip(f fs.File, fn func(name string, info fs.FileInfo, r io.Reader) (bool, error)) error {
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("read archive: %w", err)
+	}
+
+	// zip needs random access, which not every filesystem provides
+	ra, ok := f.(io.ReaderAt)
+	if !ok {
+		content, err := io.ReadAll(f)
+		if err != nil {
+			return fmt.Errorf("read archive '%s': %w", info.Name(), err)
+		}
+		ra = bytes.NewReader(content)
+	}
+
+	zr, err := zip.NewReader(ra, info.Size())
+	if err != nil {
+		return fmt.Errorf("read archive '%s': %w", info.Name(), err)
+	}
+
+	for _, entry := range zr.File {
+		done, err := walkZipEntry(entry, fn)
+		if done || err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func walkZipEntry(entry *zip.File, fn func(name string, info fs.FileInfo, r io.Reader) (bool, error)) (bool, error) {
+	rc, err := entry.Open()
+	if err != nil {
+		return false, fmt.Errorf("read archive entry '%s': %w", entry.Name, err)
+	}
+	defer misc.Close(entry.Name, rc)
+
+	return fn(entry.Name, entry.FileInfo(), rc)
+}
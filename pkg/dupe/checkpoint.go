@@ -0,0 +1,25 @@
+package dupe
+
+import (
+	"log"
+	"time"
+)
+
+// checkpointInterval is the minimum time between database writes while indexing
+const checkpointInterval = time.Minute
+
+// checkpoint writes the database if checkpoints are enabled and the interval passed since the last write.
+// Known paths are skipped when indexing, so a restarted run resumes with the files recorded so far.
+func (d *Dupe) checkpoint() {
+	if !d.config.IndexCheckpoint || time.Since(d.checkpointed) < checkpointInterval {
+		return
+	}
+	d.checkpointed = time.Now()
+
+	if d.debug() {
+		d.logf("Writing checkpoint with %d new files\n", d.added)
+	}
+	if err := d.WriteDatabase(); err != nil {
+		log.Printf("Warning: failed to write checkpoint: %s\n", err)
+	}
+}
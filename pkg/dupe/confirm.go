@@ -0,0 +1,61 @@
+package dupe
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// confirm prints a summary of the planned deletions and asks once whether to proceed.
+// Anything but yes declines, including no input at all.
+func (d *Dupe) confirm(plans []plan) (bool, error) {
+	var groups, files int
+	var bytes int64
+	for _, p := range plans {
+		deleted := 0
+		links := unlinked{}
+		for _, dec := range p.decisions {
+			if dec.delete {
+				deleted++
+				bytes += links.freedBytes(dec.file)
+			}
+		}
+		if deleted > 0 {
+			groups++
+			files += deleted
+		}
+	}
+
+	if files == 0 {
+		return true, nil
+	}
+
+	out := d.config.ConfirmOutput
+	if out == nil {
+		out = os.Stderr
+	}
+	in := d.config.ConfirmInput
+	if in == nil {
+		in = os.Stdin
+	}
+
+	fmt.Fprintf(out, "Removing %d files in %d duplicate groups, freeing %d bytes\n", files, groups, bytes)
+	if d.config.MaxDeleteBytes > 0 && bytes > d.config.MaxDeleteBytes {
+		fmt.Fprintf(out, "Deletion stops after %d bytes\n", d.config.MaxDeleteBytes)
+	}
+	fmt.Fprint(out, "Proceed? [y/N]: ")
+
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, fmt.Errorf("read confirmation: %w", err)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	fmt.Fprintln(out, "Not confirmed, nothing is deleted")
+	return false, nil
+}
@@ -0,0 +1,44 @@
+package dupe
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+
+	"github.com/lixmal/finddupes/pkg/misc"
+)
+
+// deviceInfo returns the file info of an included device with its size determined by seeking to the end,
+// as the size reported by stat is meaningless for devices. Devices of unknown size return nil.
+func (d *Dupe) deviceInfo(path string, info fs.FileInfo) (fs.FileInfo, error) {
+	f, err := d.fs.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("walk: open device: %w", err)
+	}
+	defer misc.Close(path, f)
+
+	seeker, ok := f.(io.Seeker)
+	if !ok {
+		log.Printf("Warning: skipping device '%s': size unknown\n", path)
+		return nil, nil
+	}
+	size, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil || size == 0 {
+		// e.g. character devices streaming data
+		log.Printf("Warning: skipping device '%s': size unknown\n", path)
+		return nil, nil
+	}
+
+	return deviceFileInfo{FileInfo: info, size: size}, nil
+}
+
+// deviceFileInfo overrides the size of a device's file info
+type deviceFileInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (i deviceFileInfo) Size() int64 {
+	return i.size
+}
@@ -1,45 +1,180 @@
 package dupe
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
 	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/lixmal/finddupes/pkg/config"
 	"github.com/lixmal/finddupes/pkg/database"
 	"github.com/lixmal/finddupes/pkg/file"
 	"github.com/lixmal/finddupes/pkg/misc"
+	"github.com/lixmal/finddupes/pkg/version"
+	"github.com/lixmal/finddupes/pkg/workerpool"
 )
 
-var ErrProcessStopped = errors.New("process was stopped")
+var (
+	ErrProcessStopped = errors.New("process was stopped")
+	ErrNoMatches      = errors.New("pattern matches no paths")
+	ErrNoDatabase     = errors.New("no database path configured")
+	ErrAmbiguousRules = errors.New("rules disagree on the file to keep")
+)
 
 type Dupe struct {
 	ctx    context.Context
 	cancel context.CancelFunc
 	done   chan struct{}
 
-	paths file.Map
+	paths  file.Map
+	inodes map[file.Inode]*file.File
+	// visitedDirs and rewalkedDirs track directories of incremental walks
+	visitedDirs  map[string]struct{}
+	rewalkedDirs map[string]bool
+	// dirFiles are the known files by directory, built once needed for directories skipped by incremental walks
+	dirFiles map[string][]*file.File
+	// oldest is the oldest mtime of files indexed with a maximum age
+	oldest time.Time
+	// vanished are the hashed files that disappeared since the last run, by size, to detect moves
+	vanished map[int64]file.Slice
+	// checkpointed is the time the database was last written while indexing
+	checkpointed time.Time
+	// manifest are known hashes by path, taken over for files not modified after manifestTime
+	manifest     map[string]string
+	manifestTime time.Time
+	// transient are the files only known for this run, e.g. of reference hash lists and databases, never written
+	transient file.Slice
+	// placeholders are the transient files of reference hash lists, which have no local path
+	placeholders map[*file.File]struct{}
+	// hashedFiles are the files hashed in this run if access times are compared, they were recorded before reading them
+	hashedFiles map[*file.File]struct{}
+
+	extAliases map[string]string
+	excludes   []exclude
+	owner      *owner
+	root       string
+	reference  bool
+	roots      []string
+	indexed    int
+	added      int
+	total      int
+
+	deletedDirs map[string]struct{}
+	summary     Summary
+	// summaryMutex guards the counts of removals, which run in parallel if enabled
+	summaryMutex sync.Mutex
+	// deletedBytes are the bytes freed (or to be freed) by deleting duplicates
+	deletedBytes int64
+	limitReached bool
+	// script receives the removals in dry runs, if enabled
+	script     *bufio.Writer
+	scriptFile *os.File
+	// removals removes duplicates in parallel, if enabled
+	removals *workerpool.Pool[removal]
+
+	errors      []error
+	errorsMutex sync.Mutex
+
+	progressMutex  sync.Mutex
+	hashed         int
+	hashedBytes    int64
+	hashTotal      int
+	hashTotalBytes int64
+	workerStats    []WorkerStats
+	// hashBatches collect the calculated hashes of every worker, added to the hashes table in batches
+	hashBatches [][]hashResult
+
+	// limiter limits the bytes read for hashing by all workers, if configured
+	limiter *misc.RateLimiter
+	// openFiles holds a slot for every file opened for hashing, bounding open file descriptors
+	openFiles chan struct{}
+
+	// newHash creates a hash of the configured algorithm, whose kind is algorithmKind
+	newHash       func() hash.Hash
+	algorithmKind string
 
 	config   config.Config
 	database *database.Database
+	out      io.Writer
+	outMutex sync.Mutex
+	fs       fs.FS
+
+	// outClosed is set once the output was closed by the reader
+	outClosed bool
 }
 
 func New(conf config.Config) *Dupe {
 	ctx, cancel := context.WithCancel(context.Background())
 	db := database.New()
 
+	out := conf.Output
+	if out == nil {
+		out = os.Stdout
+	}
+
+	fsys := conf.FS
+	if fsys == nil {
+		fsys = misc.OSFS{}
+	}
+
+	newHash, algorithmKind := newHasher(conf)
+
+	var limiter *misc.RateLimiter
+	if conf.MaxReadBytesPerSec > 0 {
+		limiter = misc.NewRateLimiter(conf.MaxReadBytesPerSec)
+	}
+
+	if conf.Verbose && conf.Verbosity < config.VerbosityVerbose {
+		conf.Verbosity = config.VerbosityVerbose
+	}
+
+	if conf.QueueDepth == 0 {
+		conf.QueueDepth = conf.Workers * 4
+	} else if conf.QueueDepth < 0 {
+		conf.QueueDepth = 0
+	}
+
+	maxOpenFiles := conf.MaxOpenFiles
+	if maxOpenFiles == 0 {
+		maxOpenFiles = defaultMaxOpenFiles()
+	}
+	var openFiles chan struct{}
+	if maxOpenFiles > 0 {
+		openFiles = make(chan struct{}, maxOpenFiles)
+	}
+
 	return &Dupe{
 		ctx:      ctx,
 		cancel:   cancel,
 		done:     make(chan struct{}),
 		config:   conf,
 		database: db,
+		out:      out,
+		fs:       fsys,
+
+		newHash:       newHash,
+		algorithmKind: algorithmKind,
+		limiter:       limiter,
+		openFiles:     openFiles,
+
+		deletedDirs:  map[string]struct{}{},
+		hashedFiles:  map[*file.File]struct{}{},
+		placeholders: map[*file.File]struct{}{},
+		extAliases:   normalizeExtAliases(conf.ExtAliases),
+		summary:      Summary{DryRun: !conf.Delete},
+		oldest:       time.Now().Add(-conf.MaxAge),
 	}
 }
 
@@ -54,41 +189,225 @@ func (d *Dupe) Stop() {
 func (d *Dupe) ProcessFiles(filePaths []string) (err error) {
 	defer close(d.done)
 
-	if d.config.Path != "" {
+	if err := d.config.Validate(); err != nil {
+		return fmt.Errorf("process files: %w", err)
+	}
+
+	if err := d.resolveOwner(); err != nil {
+		return fmt.Errorf("process files: %w", err)
+	}
+
+	if err := d.loadExcludes(); err != nil {
+		return fmt.Errorf("process files: %w", err)
+	}
+
+	if err := d.loadManifest(); err != nil {
+		return fmt.Errorf("process files: %w", err)
+	}
+
+	filePaths, err = d.expandPaths(filePaths)
+	if err != nil {
+		return fmt.Errorf("process files: %w", err)
+	}
+	d.roots = filePaths
+
+	remote := database.IsRemote(d.config.Path)
+	// a new database is always written, existing ones only if modified
+	created := false
+	switch {
+	case remote:
+		if err := d.ReadDatabase(); err != nil {
+			return fmt.Errorf("process files: %w", err)
+		}
+		d.referenceRemote()
+	case d.config.Path != "":
+		// fail before doing any expensive work
+		if err := d.checkDatabasePath(); err != nil {
+			return fmt.Errorf("process files: %w", err)
+		}
+
 		// ignore non-existent databases
-		if err := d.ReadDatabase(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		if err := d.ReadDatabase(); errors.Is(err, os.ErrNotExist) {
+			created = true
+		} else if err != nil {
 			return fmt.Errorf("process files: %w", err)
 		}
-		d.VerifyDatabase()
+	}
+	// later stages continue where an earlier run left off
+	if created && (d.config.OnlyStage == config.StageHash || d.config.OnlyStage == config.StageDelete) {
+		return fmt.Errorf("process files: %s stage requires the database '%s' of an earlier stage: %w", d.config.OnlyStage, d.config.Path, os.ErrNotExist)
 	}
 
 	defer func() {
-		if d.config.Path != "" {
+		// remote databases are read only, unchanged ones aren't written to avoid needless writes
+		if d.config.Path != "" && !remote && (created || d.database.Dirty()) {
 			if err2 := d.WriteDatabase(); err2 != nil {
 				// overwriting return err value
-				err = fmt.Errorf("process files: %w", err)
+				err = fmt.Errorf("process files: %w", err2)
 				return
 			}
 		}
 	}()
 
-	if err = d.IndexFiles(filePaths); err != nil {
-		return fmt.Errorf("process files: index files: %w", err)
+	// verified after deferring the write, so the files verified before stopping are written
+	if d.config.Path != "" && !remote {
+		if err := d.VerifyDatabase(); err != nil {
+			return fmt.Errorf("process files: %w", err)
+		}
+	}
+
+	if d.runStage(config.StageIndex) {
+		indexed, err := d.IndexFiles(filePaths)
+		if err != nil {
+			return fmt.Errorf("process files: index files: %w", err)
+		}
+		if d.verbose() {
+			d.logf("Indexed %d new files\n", indexed)
+		}
+	}
+	if d.config.OnlyStage == config.StageIndex {
+		return nil
+	}
+
+	// removed before the deferred write above
+	defer d.removeTransient()
+	if err := d.loadReferenceHashes(); err != nil {
+		return fmt.Errorf("process files: %w", err)
+	}
+	if err := d.loadReferenceDBs(); err != nil {
+		return fmt.Errorf("process files: %w", err)
+	}
+
+	// analytical only, nothing is deleted
+	if d.config.SampleFraction > 0 {
+		estimate, err := d.EstimateDuplicates()
+		if err != nil {
+			return fmt.Errorf("process files: estimate duplicates: %w", err)
+		}
+		d.printEstimate(estimate)
+		return nil
 	}
 
-	if err = d.CalculcateHashes(); err != nil {
-		return fmt.Errorf("process files: calculate hashes: %w", err)
+	if d.runStage(config.StageHash) {
+		d.applyManifest()
+		if err = d.CalculcateHashes(); err != nil {
+			return fmt.Errorf("process files: calculate hashes: %w", err)
+		}
 	}
 
-	if !d.config.StoreOnly {
+	if !d.config.StoreOnly && d.runStage(config.StageDelete) {
 		if err = d.DeleteDuplicates(); err != nil {
 			return fmt.Errorf("process files: delete duplicates: %w", err)
 		}
+
+		if !d.summary.DryRun && d.config.PruneEmptyDirs {
+			d.PruneEmptyDirs()
+		}
 	}
 
 	return
 }
 
+// runStage reports whether the stage runs, either all stages run or only the configured one
+func (d *Dupe) runStage(stage string) bool {
+	return d.config.OnlyStage == "" || d.config.OnlyStage == stage
+}
+
+// expandPaths expands glob patterns in the given paths. Paths without patterns or that exist are kept as they are.
+func (d *Dupe) expandPaths(filePaths []string) ([]string, error) {
+	var expanded []string
+	for _, path := range filePaths {
+		if !strings.ContainsAny(path, `*?[`) {
+			expanded = append(expanded, path)
+			continue
+		}
+		// existing paths are taken literally, e.g. a directory named "Photos [2020]"
+		if _, err := fs.Stat(d.fs, path); err == nil {
+			expanded = append(expanded, path)
+			continue
+		}
+
+		matches, err := fs.Glob(d.fs, path)
+		if err != nil {
+			return nil, fmt.Errorf("expand '%s': %w", path, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("expand '%s': %w", path, ErrNoMatches)
+		}
+		expanded = append(expanded, matches...)
+	}
+
+	return expanded, nil
+}
+
+// includedType reports whether files of the mode's type are indexed, regular files always are
+func (d *Dupe) includedType(mode os.FileMode) bool {
+	switch mode & os.ModeType {
+	case 0:
+		return true
+	case os.ModeDevice:
+		return d.config.IncludeModes&os.ModeDevice != 0
+	case os.ModeDevice | os.ModeCharDevice:
+		return d.config.IncludeModes&os.ModeCharDevice != 0
+	}
+	return false
+}
+
+// filterEntry returns the file info of entries that are candidates for indexing.
+// A nil info without error means the entry is skipped.
+func (d *Dupe) filterEntry(path string, entry fs.DirEntry) (fs.FileInfo, error) {
+	// skip hidden files and directories, but never the root itself
+	if d.config.SkipHidden && path != d.root && strings.HasPrefix(entry.Name(), ".") {
+		if entry.IsDir() {
+			return nil, filepath.SkipDir
+		}
+		return nil, nil
+	}
+
+	if d.excluded(path, entry.Name()) {
+		if entry.IsDir() {
+			return nil, filepath.SkipDir
+		}
+		return nil, nil
+	}
+
+	info, err := entry.Info()
+	if err != nil {
+		return nil, fmt.Errorf("walk: info: %w", err)
+	}
+
+	// only regular files, unless other types are included
+	if !d.includedType(info.Mode()) {
+		return nil, nil
+	}
+	if info.Mode()&os.ModeType != 0 {
+		if info, err = d.deviceInfo(path, info); err != nil || info == nil {
+			return nil, err
+		}
+	}
+
+	// ignore empty files, unless included
+	if info.Size() == 0 && !d.config.IncludeEmpty || d.ignoredSize(info.Size()) {
+		return nil, nil
+	}
+
+	if d.config.MaxAge > 0 && info.ModTime().Before(d.oldest) {
+		return nil, nil
+	}
+
+	return info, nil
+}
+
+// ignoredSize reports whether files of the size are configured to be ignored
+func (d *Dupe) ignoredSize(size int64) bool {
+	for _, ignored := range d.config.IgnoreSizes {
+		if size == ignored {
+			return true
+		}
+	}
+	return false
+}
+
 func (d *Dupe) walkDir(path string, entry fs.DirEntry, err error) error {
 	select {
 	case <-d.ctx.Done():
@@ -97,57 +416,185 @@ func (d *Dupe) walkDir(path string, entry fs.DirEntry, err error) error {
 	}
 
 	if err != nil {
+		d.addError(&IndexError{Path: path, Err: err})
 		return fmt.Errorf("walk: %w", err)
 	}
 
-	info, err := entry.Info()
-	if err != nil {
-		return fmt.Errorf("walk: info: %w", err)
+	info, err := d.filterEntry(path, entry)
+	if info == nil {
+		if err != nil && err != filepath.SkipDir {
+			d.addError(&IndexError{Path: path, Err: err})
+		}
+		if err == nil && entry.IsDir() && d.config.IncrementalWalk {
+			return d.visitDir(path, entry)
+		}
+		return err
 	}
 
-	// only regular files
-	if info.Mode()&os.ModeType != 0 {
-		return nil
-	}
+	d.indexed++
+	d.progress(config.StageIndex, d.indexed, d.total)
 
-	if d.config.Verbose {
-		fmt.Printf("Processing file %s\n", path)
+	if d.verbose() {
+		d.logf("Processing file %s\n", path)
 	}
 	size := info.Size()
+	mtime := info.ModTime()
 
-	// ignore empty files
-	if size == 0 {
-		return nil
+	if d.config.ScanArchives && isArchive(path) {
+		if err := d.indexArchive(path); err != nil {
+			log.Println(err)
+			d.addError(&IndexError{Path: path, Err: err})
+		}
 	}
-	mtime := info.ModTime()
+
+	stat := file.NewStat(info)
 
 	// ignore duplicate paths
-	if _, exists := d.paths[path]; exists {
+	if known, exists := d.paths[path]; exists {
+		if known.Reference != d.reference || known.Root != d.root {
+			known.Reference = d.reference
+			known.Root = d.root
+			d.database.MarkDirty()
+		}
+		d.markSeen(known)
+		d.indexInode(known, stat)
 		return nil
 	}
 
-	sys, ok := info.Sys().(*syscall.Stat_t)
-	if !ok {
-		return fmt.Errorf("not a syscall.Stat_t: %s", path)
+	if d.sameInode(path, stat) {
+		return nil
 	}
 
 	// define all new files found with "need hash" (hash field: empty string)
-	fil := &file.File{Path: path, Hash: "", Size: size, MTime: mtime, Mode: info.Mode(), Stat: sys}
+	fil := &file.File{Path: path, Hash: "", Size: size, MTime: mtime, Mode: info.Mode(), Stat: stat, Root: d.root, Reference: d.reference, LastSeenRun: d.config.RunID}
+	d.indexInode(fil, stat)
 
-	if d.database.Files[size] == nil {
-		d.database.Files[size] = file.Map{}
-	}
-	d.database.Files[size][path] = fil
+	d.database.Add(fil)
+	d.paths[path] = fil
+	d.added++
+	d.checkpoint()
 
 	return nil
 }
 
-func (d *Dupe) IndexFiles(filePaths []string) error {
+// markSeen records the current run on a known file found again, if runs are identified
+func (d *Dupe) markSeen(fil *file.File) {
+	if d.config.RunID == "" || fil.LastSeenRun == d.config.RunID {
+		return
+	}
+	fil.LastSeenRun = d.config.RunID
+	d.database.MarkDirty()
+}
+
+// indexInode remembers the inode of an indexed file, if inode deduplication is enabled
+func (d *Dupe) indexInode(fil *file.File, stat *file.Stat) {
+	if d.config.DedupByInode == "" || stat == nil {
+		return
+	}
+	if _, exists := d.inodes[stat.Inode()]; !exists {
+		d.inodes[stat.Inode()] = fil
+	}
+}
+
+// sameInode reports whether the path points to an inode already indexed under a different path.
+// Depending on the configuration the path is skipped or recorded as an alias of the indexed file.
+func (d *Dupe) sameInode(path string, stat *file.Stat) bool {
+	if d.config.DedupByInode == "" || stat == nil {
+		return false
+	}
+
+	known, exists := d.inodes[stat.Inode()]
+	if !exists {
+		return false
+	}
+
+	if d.config.DedupByInode == config.DedupByInodeAlias {
+		for _, alias := range known.Aliases {
+			if alias == path {
+				return true
+			}
+		}
+		known.Aliases = append(known.Aliases, path)
+		d.database.MarkDirty()
+	}
+
+	if d.debug() {
+		d.logf("  Same inode as %s\n", known.Path)
+	}
+
+	return true
+}
+
+// countDir counts the candidate files of a directory, applying the same filters as walkDir
+func (d *Dupe) countDir(path string, entry fs.DirEntry, err error) error {
+	select {
+	case <-d.ctx.Done():
+		return ErrProcessStopped
+	default:
+	}
+
+	if err != nil {
+		return fmt.Errorf("count: %w", err)
+	}
+
+	info, err := d.filterEntry(path, entry)
+	if info != nil {
+		d.total++
+	}
+
+	return err
+}
+
+// CountFiles counts the candidate files in the given paths without indexing them
+func (d *Dupe) CountFiles(filePaths []string) (int, error) {
+	d.total = 0
+	for _, path := range filePaths {
+		d.root = path
+		if err := misc.WalkDir(d.fs, path, d.countDir); err == ErrProcessStopped {
+			return d.total, err
+		} else if err != nil {
+			log.Println(err)
+		}
+	}
+
+	return d.total, nil
+}
+
+// IndexFiles walks the given paths and the reference paths and adds all candidate files to the database.
+// It returns the number of files that weren't known before.
+func (d *Dupe) IndexFiles(filePaths []string) (int, error) {
 	d.paths = file.Map{}
+	d.inodes = map[file.Inode]*file.File{}
+	d.visitedDirs = map[string]struct{}{}
+	d.rewalkedDirs = map[string]bool{}
 	defer func() {
 		d.paths = nil
+		d.inodes = nil
+		d.visitedDirs = nil
+		d.rewalkedDirs = nil
+		d.dirFiles = nil
 	}()
 
+	roots := append(append([]string{}, filePaths...), d.config.ReferencePaths...)
+
+	// fail before doing any work if a path is missing
+	if d.config.StrictRoots {
+		for _, path := range roots {
+			if _, err := fs.Stat(d.fs, path); err != nil {
+				return 0, fmt.Errorf("index files: %w", err)
+			}
+		}
+	}
+
+	// total stays 0 (unknown) without a pre-pass
+	d.indexed, d.added, d.total = 0, 0, 0
+	d.checkpointed = time.Now()
+	if d.config.PreCount {
+		if _, err := d.CountFiles(roots); err != nil {
+			return 0, err
+		}
+	}
+
 	// index already known paths, so we can identify duplicates later
 	for _, list := range d.database.Files {
 		for _, file := range list {
@@ -155,255 +602,1001 @@ func (d *Dupe) IndexFiles(filePaths []string) error {
 		}
 	}
 
-	for _, path := range filePaths {
-		if err := filepath.WalkDir(path, d.walkDir); err == ErrProcessStopped {
-			return err
+	for i, path := range roots {
+		d.root = path
+		d.reference = i >= len(filePaths)
+		if err := misc.WalkDir(d.fs, path, d.walkDir); err == ErrProcessStopped {
+			return d.added, err
 		} else if err != nil {
 			log.Println(err)
 		}
 	}
 
-	return nil
+	if d.config.IncrementalWalk {
+		d.pruneDirs(roots)
+	}
+
+	return d.added, nil
 }
 
-func (d *Dupe) calculateHash(wg *sync.WaitGroup, jobs <-chan *file.File) {
-	for fil := range jobs {
-		if fil == nil {
-			return
+// calculateHash hashes a single candidate file on the given worker and adds it to the hashes table
+func (d *Dupe) calculateHash(worker int, fil *file.File) {
+	defer d.hashProgress(fil.Size)
+
+	if !d.needsHash(fil) {
+		return
+	}
+	defer d.countHash(worker, fil, time.Now())
+
+	var hash string
+	var err error
+	if moved := d.movedFile(fil); moved != nil {
+		if d.verbose() {
+			d.logf("  %s moved from %s, reusing hash\n", fil.Path, moved.Path)
 		}
+		hash = moved.Hash
+		fil.PrefixHash = moved.PrefixHash
+		fil.MimeType = moved.MimeType
+		fil.Unverified = true
+	} else {
+		if d.verbose() {
+			d.logf("  Calculating hash for %s\n", fil.Path)
+		}
+		hash, err = d.hashWithRetries(fil)
+		fil.Unverified = false
+	}
+	d.collectHash(worker, fil, hash, err)
+}
 
-		select {
-		case <-d.ctx.Done():
-			wg.Done()
-			return
-		default:
+// needsHash reports whether the file isn't hashed in the current mode yet.
+// Hashes already calculated are placed in database.hashes.
+func (d *Dupe) needsHash(fil *file.File) bool {
+	return fil.Hash == "" || fil.HashKind != d.hashKind() || fil.Partial
+}
+
+// collectHash adds the calculated hash of the file to the batch of the worker, or records the error
+func (d *Dupe) collectHash(worker int, fil *file.File, hash string, err error) {
+	if err != nil {
+		log.Println(err)
+		d.addError(&HashError{Path: fil.Path, Err: err})
+		if d.config.KeepPartialHashes && hash != "" {
+			d.partialHash(fil, hash)
 		}
+		return
+	}
+
+	// only this worker accesses its batch
+	d.hashBatches[worker] = append(d.hashBatches[worker], hashResult{file: fil, hash: hash})
+	if len(d.hashBatches[worker]) >= hashBatchSize {
+		d.flushHashes(worker)
+	}
+}
+
+// hashResult is a calculated hash waiting to be added to the hashes table
+type hashResult struct {
+	file *file.File
+	hash string
+}
+
+// flushHashes adds the hashes collected by the worker to the hashes table
+func (d *Dupe) flushHashes(worker int) {
+	d.addHashes(d.hashBatches[worker])
+	d.hashBatches[worker] = d.hashBatches[worker][:0]
+}
+
+// addHashes adds the calculated hashes to the hashes table, under a single lock of the database
+func (d *Dupe) addHashes(batch []hashResult) {
+	if len(batch) == 0 {
+		return
+	}
+
+	d.database.Lock()
+	defer d.database.Unlock()
 
-		// hash already calculated and placed in database.hashes
+	for _, result := range batch {
+		fil := result.file
+
+		// hashed in a different mode before
 		if fil.Hash != "" {
-			wg.Done()
-			continue
+			delete(d.database.Hashes[fil.HashKey()], fil.Path)
 		}
 
-		if d.config.Verbose {
-			fmt.Printf("  Calculating hash for %s\n", fil.Path)
-		}
-		hash, err := misc.Hash(fil.Path)
-		if err != nil {
-			log.Println(err)
-			wg.Done()
-			continue
-		}
-		fil.Hash = hash
+		fil.Hash = result.hash
+		fil.HashKind = d.hashKind()
+		fil.Partial = false
+		d.checkCollision(fil)
 
-		d.database.Lock()
-		if d.database.Hashes[hash] == nil {
-			d.database.Hashes[hash] = file.Map{}
+		d.database.AddHash(fil)
+		if d.config.KeepRecentAccess || d.config.KeepOldestAccess {
+			d.hashedFiles[fil] = struct{}{}
 		}
-		d.database.Hashes[hash][fil.Path] = fil
-		if d.config.Verbose {
-			fmt.Printf("  Path: %s\n", fil.Path)
-			fmt.Printf("  Hash: %x\n", hash)
+		if d.debug() {
+			d.logf("  Path: %s\n", fil.Path)
+			d.logf("  Hash: %s\n", fil.HashString())
 		}
-		d.database.Unlock()
+	}
+}
+
+// partialHash records the hash of a file that couldn't be read completely.
+// The file is kept out of the hashes table, so it is never considered a duplicate, and hashed again on the next run.
+func (d *Dupe) partialHash(fil *file.File, hash string) {
+	d.database.Lock()
+	defer d.database.Unlock()
+
+	if fil.Hash != "" {
+		delete(d.database.Hashes[fil.HashKey()], fil.Path)
+	}
+
+	fil.Hash = hash
+	fil.HashKind = d.hashKind()
+	fil.Partial = true
+	d.database.MarkDirty()
+	if d.debug() {
+		d.logf("  Path: %s\n", fil.Path)
+		d.logf("  Partial hash: %s\n", fil.HashString())
+	}
+}
+
+// checkCollision warns if a file with the same full content hash but a different size is already known.
+// This can't happen for true duplicates and hints at a hash collision, corruption or a bug.
+// The database must be locked.
+func (d *Dupe) checkCollision(fil *file.File) {
+	// only hashes over the full content imply equal sizes
+	if d.normalized() || d.config.PrefixOnly {
+		return
+	}
 
-		wg.Done()
+	for _, other := range d.database.Hashes[fil.HashKey()] {
+		if other.Size != fil.Size {
+			log.Printf("Warning: hash collision between files of different sizes: '%s' (%d bytes) and '%s' (%d bytes)\n", fil.Path, fil.Size, other.Path, other.Size)
+			return
+		}
 	}
 }
 
-func (d *Dupe) CalculcateHashes() (err error) {
-	jobs := make(chan *file.File)
+// progress reports the progress of a stage to the configured callback
+func (d *Dupe) progress(stage string, done, total int) {
+	if d.config.OnProgress == nil {
+		return
+	}
+
+	d.progressMutex.Lock()
+	defer d.progressMutex.Unlock()
+	d.config.OnProgress(config.Progress{Stage: stage, Done: done, Total: total})
+}
 
-	var wg sync.WaitGroup
-	// start workers
-	for w := 1; w <= d.config.Workers; w++ {
-		go d.calculateHash(&wg, jobs)
+// hashProgress counts a processed hash job of the given size and reports it
+func (d *Dupe) hashProgress(size int64) {
+	if d.config.OnProgress == nil {
+		return
 	}
 
-	// distribute work
+	d.progressMutex.Lock()
+	defer d.progressMutex.Unlock()
+	d.hashed++
+	d.hashedBytes += size
+	d.config.OnProgress(config.Progress{
+		Stage:      config.StageHash,
+		Done:       d.hashed,
+		Total:      d.hashTotal,
+		Bytes:      d.hashedBytes,
+		TotalBytes: d.hashTotalBytes,
+	})
+}
+
+// bytesProgress reports the bytes read so far while hashing a large file
+func (d *Dupe) bytesProgress(fil *file.File, done int64) {
+	d.progressMutex.Lock()
+	defer d.progressMutex.Unlock()
+	d.config.OnBytes(fil.Path, done, fil.Size)
+}
+
+func (d *Dupe) CalculcateHashes() error {
 	// go through all files and see if we need to calculate hashes somewhere
-outer:
+	var candidates file.Slice
 	for size, files := range d.database.Files {
-		// only process possible dupes (based on file size)
+		// only process possible dupes (based on file size), unless all files are hashed
 		length := len(files)
-		if length < 2 {
+		if (length < 2 && d.sizeShortcut()) || d.ignoredSize(size) {
 			continue
 		}
 
-		if d.config.Verbose {
-			fmt.Printf("Found %d elements for size %d\n", length, size)
+		if d.debug() {
+			d.logf("Found %d elements for size %d\n", length, size)
 		}
 
 		for _, file := range files {
-			select {
-			case <-d.ctx.Done():
-				err = ErrProcessStopped
-				break outer
-			default:
-			}
-
-			wg.Add(1)
-			jobs <- file
+			candidates = append(candidates, file)
 		}
 	}
-	close(jobs)
 
+	return d.hashCandidates(candidates)
+}
+
+// hashCandidates hashes the files with the configured workers
+func (d *Dupe) hashCandidates(candidates file.Slice) error {
+	switch d.config.HashOrder {
+	case config.HashOrderLargestFirst:
+		candidates.SortBySize(file.SortDescending)
+	case config.HashOrderSmallestFirst:
+		candidates.SortBySize(file.SortAscending)
+	}
+
+	d.hashed, d.hashedBytes = 0, 0
+	d.hashTotal, d.hashTotalBytes = len(candidates), 0
+	for _, fil := range candidates {
+		d.hashTotalBytes += fil.Size
+	}
+
+	// hardlinks are read once, the other paths get the hash after the workers finished
+	candidates, links := splitHardlinks(candidates)
+	defer d.linkHashes(links)
+
+	d.workerStats = make([]WorkerStats, d.config.Workers)
+	d.hashBatches = make([][]hashResult, d.config.Workers)
+	// runs after the workers finished, also if stopped, so no calculated hash is lost
+	defer func() {
+		for worker := range d.hashBatches {
+			d.flushHashes(worker)
+		}
+		d.hashBatches = nil
+	}()
+	pool := workerpool.New(d.ctx, d.config.Workers, d.config.QueueDepth, func(worker int, job hashJob) {
+		if job.entries != nil {
+			d.hashArchive(worker, job.entries)
+			return
+		}
+		d.calculateHash(worker, job.file)
+	})
 	// wait for all workers to finish their work
-	wg.Wait()
+	defer pool.Close()
 
-	return
+	// distribute work
+	for _, job := range d.hashJobs(candidates) {
+		if pool.Submit(job) != nil {
+			return ErrProcessStopped
+		}
+	}
+
+	return nil
 }
 
-func (d *Dupe) DeleteDuplicates() error {
-	for hash, files := range d.database.Hashes {
-		length := len(files)
+// removal is a duplicate to be removed in favor of the survivor of its group
+type removal struct {
+	file     *file.File
+	survivor *file.File
+	// freed are the bytes removing the file frees
+	freed int64
+}
 
+func (d *Dupe) DeleteDuplicates() (err error) {
+	if d.config.ScriptPath != "" && !d.config.Delete {
+		if err := d.openScript(); err != nil {
+			return err
+		}
+		defer func() {
+			if err2 := d.closeScript(); err2 != nil && err == nil {
+				err = err2
+			}
+		}()
+	}
+
+	if err := d.verifyTakenOver(); err != nil {
+		return err
+	}
+
+	var groups []file.Slice
+	for _, files := range d.database.Hashes {
 		// no duplicates for this hash
-		if length < 2 {
+		if len(files) < 2 {
 			continue
 		}
 
-		processed := 0
-		fmt.Printf("Found %d elements for hash %x:\n", length, hash)
-
 		fileSlice := files.ToSlice().SortByPath()
+		if !d.inShard(fileSlice[0]) {
+			continue
+		}
+		// might be known from a previous run
+		if d.ignoredSize(fileSlice[0].Size) {
+			continue
+		}
+		if d.config.MimeFilter != "" {
+			fileSlice = d.filterMime(fileSlice)
+		}
 
-		for i, file := range fileSlice {
-			select {
-			case <-d.ctx.Done():
-				return ErrProcessStopped
-			default:
-			}
+		groups = append(groups, d.partition(fileSlice)...)
+	}
+	// map order is random, runs stopped by the deletion limit must be reproducible
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := groups[i][0], groups[j][0]
+		if a.HashKey() != b.HashKey() {
+			return a.HashKey() < b.HashKey()
+		}
+		return a.Path < b.Path
+	})
 
-			fmt.Printf("  %s\n", file.Path)
+	plans, err := d.planGroups(groups)
+	if err != nil {
+		return err
+	}
+	if err := d.checkConflicts(plans); err != nil {
+		return err
+	}
 
-			// no duplicates left
-			if length-processed < 2 {
-				break
-			}
+	// reported as a dry run if there are too few duplicates or deletion is declined, this also skips pruning directories
+	deleting := d.config.Delete
+	if deleting && !d.enoughDuplicates(groups) {
+		deleting = false
+	}
+	if deleting && d.config.ConfirmOnce {
+		confirmed, err := d.confirm(plans)
+		if err != nil {
+			return err
+		}
+		deleting = confirmed
+	}
+	d.summary.DryRun = !deleting
 
-			// no deletion rules matched
-			if !d.matchRules(fileSlice, i, file) {
-				continue
+	if deleting && d.config.DeleteWorkers > 1 {
+		d.removals = workerpool.New(d.ctx, d.config.DeleteWorkers, d.config.DeleteWorkers, func(_ int, r removal) {
+			if d.removeDuplicate(r.file, r.survivor) {
+				d.countRemoval(r.freed)
 			}
+		})
+		// wait for all removals before returning
+		defer func() {
+			d.removals.Close()
+			d.removals = nil
+		}()
+	}
+
+	for _, p := range plans {
+		if err := d.deleteGroup(p, deleting); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// inShard reports whether the hash of the file falls into the configured shard.
+// Shards are assigned by hash, so every machine of a sharded run agrees on the groups of each shard.
+func (d *Dupe) inShard(fil *file.File) bool {
+	if d.config.HashShard == "" {
+		return true
+	}
+	// validated before
+	index, count, _ := config.ParseShard(d.config.HashShard)
 
-			// add processed even if deletion fails, to be safe
-			processed++
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(fil.HashKey()))
+	return int(h.Sum32()%uint32(count)) == index
+}
+
+// enoughDuplicates reports whether the groups contain at least the configured minimum of redundant copies in total.
+func (d *Dupe) enoughDuplicates(groups []file.Slice) bool {
+	if d.config.MinTotalDuplicates <= 0 {
+		return true
+	}
+
+	total := 0
+	for _, group := range groups {
+		total += len(group) - 1
+	}
+	if total >= d.config.MinTotalDuplicates {
+		return true
+	}
+
+	log.Printf("Warning: found %d duplicates, less than the minimum of %d, not deleting any files\n", total, d.config.MinTotalDuplicates)
+	return false
+}
+
+// plan holds the decisions made for a group of duplicates
+type plan struct {
+	files     file.Slice
+	decisions []decision
+	survivor  *file.File
+	// conflict is the file kept by the positional rules, if the pattern rules keep another one
+	conflict *file.File
+}
+
+// planGroups applies the rules to all groups in parallel.
+// Decisions of a group don't depend on other groups, all of them are made before anything is deleted.
+func (d *Dupe) planGroups(groups []file.Slice) ([]plan, error) {
+	workers := d.config.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	plans := make([]plan, len(groups))
+	pool := workerpool.New(d.ctx, workers, d.config.QueueDepth, func(_ int, i int) {
+		// decided when the group is selected interactively
+		if d.config.KeepSelector != nil {
+			plans[i] = plan{files: groups[i]}
+			return
+		}
+		decisions, conflict := d.decide(groups[i])
+		plans[i] = plan{files: groups[i], decisions: decisions, survivor: keptFile(decisions), conflict: conflict}
+	})
+
+	for i := range groups {
+		if pool.Submit(i) != nil {
+			break
+		}
+	}
+	pool.Close()
+
+	// workers may have skipped groups
+	if d.ctx.Err() != nil {
+		return nil, ErrProcessStopped
+	}
+
+	return plans, nil
+}
+
+// deleteGroup reports a group of duplicates and deletes the files matching the rules, if deleting
+func (d *Dupe) deleteGroup(p plan, deleting bool) error {
+	fileSlice, decisions, survivor := p.files, p.decisions, p.survivor
+
+	if d.config.KeepSelector != nil {
+		var err error
+		if decisions, err = d.selectSurvivor(fileSlice); err != nil {
+			return err
+		}
+		survivor = keptFile(decisions)
+	}
+	survivor = d.localSurvivor(decisions, survivor)
+
+	// groups kept completely by the rules are omitted from the report if configured
+	report := d.report
+	if d.config.ReportActedOnly && !deletesAny(decisions) {
+		report = func(string, ...any) {}
+	}
+
+	report("Found %d elements for hash %s:\n", len(fileSlice), fileSlice[0].ShortHashString(d.config.HashDisplayLen))
+	d.summary.Groups++
+	d.summary.Duplicates += len(fileSlice) - 1
+	d.summary.countGroup(fileSlice)
 
-			if d.config.Delete {
-				d.deleteFile(file)
+	d.scriptGroup(fileSlice, survivor)
+	d.fdupesGroup(fileSlice)
+
+	links := unlinked{}
+	for _, dec := range decisions {
+		select {
+		case <-d.ctx.Done():
+			return ErrProcessStopped
+		default:
+		}
+
+		if len(d.roots)+len(d.config.ReferencePaths) > 1 && dec.file.Root != "" {
+			report("  %s (in %s)\n", dec.file.Path, dec.file.Root)
+		} else {
+			report("  %s\n", dec.file.Path)
+		}
+		for _, alias := range dec.file.Aliases {
+			report("    = %s\n", alias)
+		}
+		if dec.reason != "" {
+			report("  ↳ %s\n", dec.reason)
+		}
+
+		// last say of embedding applications
+		if dec.delete && deleting && d.config.OnBeforeDelete != nil && !d.config.OnBeforeDelete(dec.file, survivor) {
+			report("  ↳ vetoed, keeping\n")
+			dec.delete = false
+		}
+
+		var freed int64
+		if dec.delete {
+			freed = links.freedBytes(dec.file)
+		}
+
+		// no deletion rules matched, or stopped deleting
+		if !dec.delete || !d.withinLimit(dec.file, freed) {
+			if d.config.OutputFormat == config.OutputKept {
+				d.printf("%s\n", dec.file.Path)
 			}
+			continue
+		}
+
+		if d.config.OutputFormat == config.OutputNull {
+			d.printf("%s\x00", dec.file.Path)
+		}
+		d.scriptRemoval(dec.file, survivor)
 
+		if !deleting {
+			d.countRemoval(freed)
+			continue
+		}
+
+		// all decisions of the group are made, so parallel removals can't remove the survivor
+		if d.removals != nil {
+			if d.removals.Submit(removal{file: dec.file, survivor: survivor, freed: freed}) != nil {
+				return ErrProcessStopped
+			}
+			continue
+		}
+		if d.removeDuplicate(dec.file, survivor) {
+			d.countRemoval(freed)
 		}
 	}
 
 	return nil
 }
 
-func (d *Dupe) matchRules(fileSlice file.Slice, i int, fil *file.File) (matched bool) {
-	switch {
-	case d.config.KeepRecent && fil != fileSlice.Clone().SortByTime(file.SortDescending)[0]:
-		fmt.Printf("  ↳ not most recent entry\n")
-		matched = true
-	case d.config.KeepOldest && fil != fileSlice.Clone().SortByTime(file.SortAscending)[0]:
-		fmt.Printf("  ↳ not oldest entry\n")
-		matched = true
-	case d.config.KeepFirst && i != 0:
-		fmt.Printf("  ↳ not first entry\n")
-		matched = true
-	case d.config.KeepLast && i != len(fileSlice)-1:
-		fmt.Printf("  ↳ not last entry\n")
-		matched = true
-	case d.config.DelMatch != nil && d.config.DelMatch.MatchString(fil.Path):
-		fmt.Printf("  ↳ matches del regex\n")
-		matched = true
-	case d.config.KeepMatch != nil && !d.config.KeepMatch.MatchString(fil.Path):
-		fmt.Printf("  ↳ does not match keep regex\n")
-		matched = true
+// countRemoval counts a removed duplicate, or one to be removed in a dry run, in the summary
+func (d *Dupe) countRemoval(freed int64) {
+	d.summaryMutex.Lock()
+	defer d.summaryMutex.Unlock()
+
+	d.summary.Removed++
+	d.summary.FreedBytes += freed
+}
+
+// fdupesGroup prints all paths of the group followed by a blank line, if the fdupes output format is configured
+func (d *Dupe) fdupesGroup(fileSlice file.Slice) {
+	if d.config.OutputFormat != config.OutputFdupes {
+		return
+	}
+
+	for _, fil := range fileSlice {
+		d.printf("%s\n", fil.Path)
+		for _, alias := range fil.Aliases {
+			d.printf("%s\n", alias)
+		}
+	}
+	d.printf("\n")
+}
+
+// withinLimit reports whether deleting the file keeps the freed bytes within the configured maximum and counts them.
+// Once the maximum is reached, no further files are deleted.
+func (d *Dupe) withinLimit(fil *file.File, freed int64) bool {
+	if d.config.MaxDeleteBytes <= 0 {
+		return true
+	}
+
+	if !d.limitReached {
+		if d.deletedBytes+freed <= d.config.MaxDeleteBytes {
+			d.deletedBytes += freed
+			return true
+		}
+
+		d.limitReached = true
+		log.Printf("Warning: deleting %s would exceed the maximum of %d bytes to delete, not deleting any further files\n", fil.Path, d.config.MaxDeleteBytes)
+	}
+
+	d.report("  ↳ maximum of bytes to delete reached, not deleting\n")
+	return false
+}
+
+// unlinked counts the deleted links of inodes with multiple hard links
+type unlinked map[file.Inode]uint64
+
+// freedBytes returns the bytes freed by deleting the file, counting it as deleted.
+// Space of files with other hard links is only freed once the last link is deleted.
+func (u unlinked) freedBytes(fil *file.File) int64 {
+	if fil.Stat == nil || fil.Stat.Nlink <= 1 {
+		return fil.Size
+	}
+
+	inode := fil.Stat.Inode()
+	u[inode]++
+	if u[inode] < fil.Stat.Nlink {
+		return 0
+	}
+	return fil.Size
+}
+
+// filterMime returns only the files whose content type matches the configured pattern
+func (d *Dupe) filterMime(fileSlice file.Slice) (filtered file.Slice) {
+	for _, fil := range fileSlice {
+		// hashed without detection, e.g. in a previous run
+		if fil.MimeType == "" {
+			mimeType, err := d.sniffMime(fil)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			fil.MimeType = mimeType
+			d.database.MarkDirty()
+		}
+
+		// invalid patterns are rejected before, see config.Validate
+		if ok, _ := filepath.Match(d.config.MimeFilter, fil.MimeType); ok {
+			filtered = append(filtered, fil)
+		}
 	}
 	return
 }
 
-func (d *Dupe) deleteFile(file *file.File) {
-	fmt.Printf("  ↳ deleting...\n")
+// deleteFile deletes the duplicate and reports whether it's gone
+func (d *Dupe) deleteFile(file *file.File) bool {
+	// last line of defense, rules have been checked before
+	if !d.deleteAllowed(file.Path) {
+		d.reportRemoval(file, "outside of allowed roots, not deleting\n")
+		return false
+	}
+
+	d.reportRemoval(file, "deleting...\n")
 	if err := os.Remove(file.Path); err != nil {
-		fmt.Printf("  ↳ error deleting %s\n", err)
+		d.reportRemoval(file, "error deleting %s\n", err)
+		d.addError(&DeleteError{Path: file.Path, Err: err})
+	}
+
+	if _, err := os.Stat(file.Path); err == nil {
+		return false
 	}
 
-	if _, err := os.Stat(file.Path); err != nil {
-		if d.database.Files[file.Size] != nil {
-			delete(d.database.Files[file.Size], file.Path)
+	d.database.Lock()
+	d.deletedDirs[filepath.Dir(file.Path)] = struct{}{}
+	d.database.Remove(file)
+	d.database.Unlock()
+	return true
+}
+
+// PruneEmptyDirs removes directories that were left empty by deleted duplicates.
+// Directories are removed bottom-up, but only below the scanned paths.
+func (d *Dupe) PruneEmptyDirs() {
+	dirs := make([]string, 0, len(d.deletedDirs))
+	for dir := range d.deletedDirs {
+		dirs = append(dirs, dir)
+	}
+	// deepest first
+	sort.Slice(dirs, func(i, j int) bool {
+		return len(dirs[i]) > len(dirs[j])
+	})
+
+	for _, dir := range dirs {
+		for d.belowRoot(dir) {
+			// fails for directories that are not empty
+			if err := os.Remove(dir); err != nil {
+				if !errors.Is(err, syscall.ENOTEMPTY) && !errors.Is(err, syscall.EEXIST) && !errors.Is(err, os.ErrNotExist) {
+					log.Printf("Failed to remove directory '%s': %s\n", dir, err)
+				}
+				break
+			}
+			d.report("Removed empty directory %s\n", dir)
+
+			dir = filepath.Dir(dir)
 		}
-		delete(d.database.Hashes[file.Hash], file.Path)
+	}
+
+	d.deletedDirs = map[string]struct{}{}
+}
+
+// belowRoot reports whether the path is located below, but is not, one of the scanned paths
+func (d *Dupe) belowRoot(path string) bool {
+	for _, root := range d.roots {
+		if misc.UnderRoot(path, root) && filepath.Clean(path) != filepath.Clean(root) {
+			return true
+		}
+	}
+	return false
+}
+
+// printf writes to the configured output.
+// Writes aren't buffered, so the output shows up right away in pipelines.
+// If the output is a pipe closed by the reader, e.g. head, processing is stopped, there's nobody left to report to.
+func (d *Dupe) printf(format string, a ...any) {
+	d.outMutex.Lock()
+	defer d.outMutex.Unlock()
+	if d.outClosed {
+		return
+	}
+
+	if _, err := fmt.Fprintf(d.out, format, a...); errors.Is(err, syscall.EPIPE) {
+		d.outClosed = true
+		log.Println("Output closed, stopping")
+		d.Stop()
+	}
+}
+
+// logf prints a verbose or debug message.
+// Machine-readable output formats are kept free of them, they're printed to stderr instead.
+func (d *Dupe) logf(format string, a ...any) {
+	if d.config.OutputFormat == "" || d.config.OutputFormat == config.OutputText {
+		d.printf(format, a...)
+		return
+	}
+	fmt.Fprintf(os.Stderr, format, a...)
+}
+
+// verbose reports whether messages about every processed file are enabled
+func (d *Dupe) verbose() bool {
+	return d.config.Verbosity >= config.VerbosityVerbose
+}
+
+// debug reports whether debug messages, e.g. hashes of every file, are enabled
+func (d *Dupe) debug() bool {
+	return d.config.Verbosity >= config.VerbosityDebug
+}
+
+// reportRemoval reports about removing a duplicate.
+// Removals running in parallel can't rely on the file being printed right before, so the path is included.
+func (d *Dupe) reportRemoval(fil *file.File, format string, a ...any) {
+	if d.removals != nil {
+		d.report("%s: "+format, append([]any{fil.Path}, a...)...)
+		return
+	}
+	d.report("  ↳ "+format, a...)
+}
+
+// report writes to the configured output, unless a machine readable output format is selected
+func (d *Dupe) report(format string, a ...any) {
+	if d.config.Verbosity <= config.VerbosityQuiet {
+		return
+	}
+	if d.config.OutputFormat == "" || d.config.OutputFormat == config.OutputText {
+		d.printf(format, a...)
 	}
 }
 
 func (d *Dupe) ReadDatabase() error {
-	return d.database.Read(d.config.Path)
+	if err := d.database.Read(d.config.Path, d.config.DBFormat); err != nil {
+		return err
+	}
+
+	current := version.Get()
+	switch {
+	case d.database.Version == "":
+		log.Printf("Warning: database '%s' was written by an unknown older version, stored data might be interpreted differently\n", d.config.Path)
+	case !version.Compatible(d.database.Version, current):
+		log.Printf("Warning: database '%s' was written by version %s, running %s, stored data might be interpreted differently\n", d.config.Path, d.database.Version, current)
+	}
+
+	return nil
 }
 
 func (d *Dupe) WriteDatabase() error {
-	return d.database.Write(d.config.Path)
+	if d.config.DBNoFollow && database.IsSymlink(d.config.Path) {
+		return fmt.Errorf("write database '%s': %w", d.config.Path, database.ErrSymlink)
+	}
+	return d.database.WriteTemp(d.config.Path, d.config.DBFormat, d.config.TempDir)
 }
 
-func (d *Dupe) VerifyDatabase() {
-	// check stored files for changes
-	for hash, files := range d.database.Hashes {
+// checkDatabasePath verifies that the database can be written, without modifying anything.
+// A symlinked path is written through to its target, unless refused by config.
+func (d *Dupe) checkDatabasePath() error {
+	if database.IsSymlink(d.config.Path) {
+		if d.config.DBNoFollow {
+			return fmt.Errorf("database '%s': %w", d.config.Path, database.ErrSymlink)
+		}
+		target, err := database.ResolveLink(d.config.Path)
+		if err != nil {
+			return fmt.Errorf("database '%s': %w", d.config.Path, err)
+		}
+		if d.verbose() {
+			d.logf("Database '%s' is a symlink, writing to '%s'\n", d.config.Path, target)
+		}
+	}
+	return database.CheckWritable(d.config.Path)
+}
+
+// referenceRemote prepares the files of a remote database for comparison with the local ones.
+// They don't exist locally, so they are reference files that are never deleted, and can't be hashed.
+func (d *Dupe) referenceRemote() {
+	for _, files := range d.database.Files {
+		for _, fil := range files {
+			if fil.Hash == "" || fil.Partial {
+				d.database.Remove(fil)
+				continue
+			}
+			fil.Reference = true
+		}
+	}
+}
+
+// VerifyDatabase removes files that vanished from the database and marks changed files for hashing.
+// It returns ErrProcessStopped if stopped, files not verified until then are left as they are.
+func (d *Dupe) VerifyDatabase() error {
+	// archive entries are read from the archives again while indexing
+	for _, files := range d.database.Files {
 		for _, fil := range files {
+			if fil.Archive != "" {
+				d.database.Remove(fil)
+			}
+		}
+	}
+
+	// check stored files for changes, also catches changed files in directories skipped by incremental walks
+	for _, files := range d.database.Files {
+		for _, fil := range files {
+			select {
+			case <-d.ctx.Done():
+				return ErrProcessStopped
+			default:
+			}
+
 			path := fil.Path
-			if info, err := os.Stat(path); err != nil {
+			if info, err := fs.Stat(d.fs, path); err != nil {
 				// doesn't exist or not accessible
 
-				if d.config.Verbose {
-					fmt.Printf("%s vanished or not accessible, removing\n", path)
-				}
-				delete(d.database.Hashes[hash], path)
-				if d.database.Files[fil.Size] != nil {
-					delete(d.database.Files[fil.Size], path)
+				if d.verbose() {
+					d.logf("%s vanished or not accessible, removing\n", path)
 				}
+				d.database.Remove(fil)
+				d.rememberVanished(fil)
 
-			} else if info.ModTime() != fil.MTime {
+			} else if !info.ModTime().Equal(fil.MTime) {
 				// mtime changed, mark for hash recalculation
 
-				if d.config.Verbose {
-					fmt.Printf("Mtime of %s changed, need to recalculate hash\n", path)
+				if d.verbose() {
+					d.logf("Mtime of %s changed, need to recalculate hash\n", path)
 				}
 
 				// always remove first
-				delete(d.database.Hashes[fil.Hash], path)
-				if d.database.Files[fil.Size] != nil {
-					delete(d.database.Files[fil.Size], path)
-				}
+				d.database.Remove(fil)
 
 				mode := info.Mode()
 				size := info.Size()
 				// remove if not a regular file anymore or size is 0
-				if mode&os.ModeType != fil.Mode&os.ModeType || size == 0 {
-					if d.config.Verbose {
-						fmt.Printf("%s not a file anymore or file size 0, removing\n", path)
+				if mode&os.ModeType != fil.Mode&os.ModeType || size == 0 && !d.config.IncludeEmpty {
+					if d.verbose() {
+						d.logf("%s not a file anymore or file size 0, removing\n", path)
 					}
 
 					// don't read to map further below
 					continue
 				}
 
-				sys, ok := info.Sys().(*syscall.Stat_t)
-				if !ok {
-					log.Printf("Warning: not a syscall.Stat_t: %s\n", path)
-				}
-
 				fil.MTime = info.ModTime()
 				fil.Size = size
 				fil.Hash = ""
+				fil.HashKind = ""
+				fil.PrefixHash = ""
+				fil.Partial = false
 				fil.Mode = mode
-				fil.Stat = sys
+				fil.Stat = file.NewStat(info)
 
 				// add to new one
-				if d.database.Files[size] == nil {
-					d.database.Files[size] = file.Map{}
-				}
-				d.database.Files[size][path] = fil
+				d.database.Add(fil)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Prune reads the database, removes all files that no longer exist and writes it back.
+// Nothing is indexed or hashed. It returns the number of removed files. If stopped, ErrProcessStopped is returned
+// and the database isn't written.
+func (d *Dupe) Prune() (int, error) {
+	if d.config.Path == "" {
+		return 0, fmt.Errorf("prune: %w", ErrNoDatabase)
+	}
+	if err := d.checkDatabasePath(); err != nil {
+		return 0, fmt.Errorf("prune: %w", err)
+	}
+	if err := d.ReadDatabase(); err != nil {
+		return 0, fmt.Errorf("prune: %w", err)
+	}
+
+	pruned := 0
+	for _, files := range d.database.Files {
+		for _, fil := range files {
+			select {
+			case <-d.ctx.Done():
+				return pruned, ErrProcessStopped
+			default:
+			}
+
+			if !d.missing(fil) {
+				continue
+			}
+
+			if d.verbose() {
+				d.logf("%s vanished, removing\n", fil.Path)
+			}
+			d.database.Remove(fil)
+			pruned++
+		}
+	}
+
+	if err := d.WriteDatabase(); err != nil {
+		return pruned, fmt.Errorf("prune: %w", err)
+	}
+
+	return pruned, nil
+}
+
+// DumpDatabase reads the database and writes all stored files to w, grouped by hash, for inspection.
+// Each group starts with a line of the hash, followed by a line per file of tab separated size, mtime, mode and path.
+// Files not hashed yet are listed last, under "unhashed". Nothing is modified.
+func (d *Dupe) DumpDatabase(w io.Writer) error {
+	if d.config.Path == "" {
+		return fmt.Errorf("dump database: %w", ErrNoDatabase)
+	}
+	if err := d.ReadDatabase(); err != nil {
+		return fmt.Errorf("dump database: %w", err)
+	}
+
+	groups := map[string]file.Slice{}
+	var keys []string
+	var unhashed file.Slice
+	for _, files := range d.database.Files {
+		for _, fil := range files {
+			if fil.Hash == "" {
+				unhashed = append(unhashed, fil)
+				continue
+			}
+			key := fil.HashString()
+			if groups[key] == nil {
+				keys = append(keys, key)
 			}
+			groups[key] = append(groups[key], fil)
 		}
 	}
+	sort.Strings(keys)
+
+	bw := bufio.NewWriter(w)
+	dumpGroup := func(name string, files file.Slice) {
+		fmt.Fprintf(bw, "%s (%d files)\n", name, len(files))
+		for _, fil := range files.SortByPath() {
+			fmt.Fprintf(bw, "\t%d\t%s\t%s\t%s\n", fil.Size, fil.MTime.Format(time.RFC3339Nano), fil.Mode, fil.Path)
+		}
+	}
+	for _, key := range keys {
+		dumpGroup(key, groups[key])
+	}
+	if len(unhashed) > 0 {
+		dumpGroup("unhashed", unhashed)
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("dump database: %w", err)
+	}
+	return nil
+}
+
+// SetHash reads the database and returns the fingerprint of its hashed files, see database.SetHash.
+// Nothing is modified.
+func (d *Dupe) SetHash(contentOnly bool) (string, error) {
+	if d.config.Path == "" {
+		return "", fmt.Errorf("set hash: %w", ErrNoDatabase)
+	}
+	if err := d.ReadDatabase(); err != nil {
+		return "", fmt.Errorf("set hash: %w", err)
+	}
+
+	return d.database.SetHash(contentOnly), nil
+}
+
+// MissingFiles reads the database and returns all stored files that no longer exist, sorted by path.
+// Unlike Prune, neither the database nor any file is modified.
+func (d *Dupe) MissingFiles() (file.Slice, error) {
+	if d.config.Path == "" {
+		return nil, fmt.Errorf("list missing: %w", ErrNoDatabase)
+	}
+	if err := d.ReadDatabase(); err != nil {
+		return nil, fmt.Errorf("list missing: %w", err)
+	}
+
+	var missing file.Slice
+	for _, files := range d.database.Files {
+		for _, fil := range files {
+			select {
+			case <-d.ctx.Done():
+				return missing.SortByPath(), ErrProcessStopped
+			default:
+			}
+
+			if d.missing(fil) {
+				missing = append(missing, fil)
+			}
+		}
+	}
+
+	return missing.SortByPath(), nil
+}
+
+// missing reports whether the file no longer exists.
+// Other errors are logged and don't count, they might be temporary, e.g. an unmounted drive.
+func (d *Dupe) missing(fil *file.File) bool {
+	_, err := fs.Stat(d.fs, fil.Path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Println(err)
+		return false
+	}
+	return err != nil
 }
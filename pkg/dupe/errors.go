@@ -0,0 +1,60 @@
+package dupe
+
+import "fmt"
+
+// IndexError is recorded if a path couldn't be indexed
+type IndexError struct {
+	Path string
+	Err  error
+}
+
+func (e *IndexError) Error() string {
+	return fmt.Sprintf("index '%s': %s", e.Path, e.Err)
+}
+
+func (e *IndexError) Unwrap() error {
+	return e.Err
+}
+
+// HashError is recorded if a file couldn't be hashed
+type HashError struct {
+	Path string
+	Err  error
+}
+
+func (e *HashError) Error() string {
+	return fmt.Sprintf("hash '%s': %s", e.Path, e.Err)
+}
+
+func (e *HashError) Unwrap() error {
+	return e.Err
+}
+
+// DeleteError is recorded if a duplicate couldn't be deleted or replaced
+type DeleteError struct {
+	Path string
+	Err  error
+}
+
+func (e *DeleteError) Error() string {
+	return fmt.Sprintf("delete '%s': %s", e.Path, e.Err)
+}
+
+func (e *DeleteError) Unwrap() error {
+	return e.Err
+}
+
+// Errors returns all errors that were encountered and skipped during processing
+func (d *Dupe) Errors() []error {
+	d.errorsMutex.Lock()
+	defer d.errorsMutex.Unlock()
+
+	return append([]error{}, d.errors...)
+}
+
+func (d *Dupe) addError(err error) {
+	d.errorsMutex.Lock()
+	defer d.errorsMutex.Unlock()
+
+	d.errors = append(d.errors, err)
+}
@@ -0,0 +1,99 @@
+package dupe
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/lixmal/finddupes/pkg/file"
+)
+
+// z95 is the z-score of a 95% confidence interval
+const z95 = 1.96
+
+// Estimate is the extrapolated amount of duplicates, based on a random sample of size groups
+type Estimate struct {
+	// Fraction of size groups that were sampled
+	Fraction float64
+	// SizeGroups is the number of groups of files with equal sizes, SampledGroups the number of those hashed
+	SizeGroups    int
+	SampledGroups int
+	// Duplicates is the estimated number of redundant files, DuplicatesMargin the 95% confidence margin
+	Duplicates       float64
+	DuplicatesMargin float64
+	// Bytes is the estimated number of reclaimable bytes, BytesMargin the 95% confidence margin
+	Bytes       float64
+	BytesMargin float64
+}
+
+// EstimateDuplicates hashes a random sample of the groups of files with equal sizes and extrapolates
+// the number of redundant files and reclaimable bytes of all groups.
+// Whole size groups are sampled, as duplicates can only be detected if all files of a group are hashed.
+func (d *Dupe) EstimateDuplicates() (Estimate, error) {
+	fraction := d.config.SampleFraction
+	estimate := Estimate{Fraction: fraction}
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	var sampled []file.Slice
+	var candidates file.Slice
+	for size, files := range d.database.Files {
+		if len(files) < 2 || d.ignoredSize(size) {
+			continue
+		}
+
+		estimate.SizeGroups++
+		if rnd.Float64() >= fraction {
+			continue
+		}
+
+		group := files.ToSlice()
+		sampled = append(sampled, group)
+		candidates = append(candidates, group...)
+	}
+	estimate.SampledGroups = len(sampled)
+
+	if err := d.hashCandidates(candidates); err != nil {
+		return estimate, err
+	}
+
+	// Horvitz-Thompson estimator for Bernoulli sampling of the size groups
+	var dupVariance, bytesVariance float64
+	for _, group := range sampled {
+		duplicates := float64(countDuplicates(group))
+		bytes := duplicates * float64(group[0].Size)
+
+		estimate.Duplicates += duplicates / fraction
+		estimate.Bytes += bytes / fraction
+		dupVariance += (1 - fraction) / (fraction * fraction) * duplicates * duplicates
+		bytesVariance += (1 - fraction) / (fraction * fraction) * bytes * bytes
+	}
+	estimate.DuplicatesMargin = z95 * math.Sqrt(dupVariance)
+	estimate.BytesMargin = z95 * math.Sqrt(bytesVariance)
+
+	return estimate, nil
+}
+
+// countDuplicates returns the number of redundant files in a group of files with equal sizes
+func countDuplicates(group file.Slice) int {
+	hashes := map[string]int{}
+	for _, fil := range group {
+		if fil.Hash != "" && !fil.Partial {
+			hashes[fil.HashKey()]++
+		}
+	}
+
+	duplicates := 0
+	for _, count := range hashes {
+		duplicates += count - 1
+	}
+	return duplicates
+}
+
+// printEstimate reports the estimate, clearly labeled as such
+func (d *Dupe) printEstimate(estimate Estimate) {
+	d.report("Estimate based on %d of %d groups of equally sized files (%.1f%% sample), 95%% confidence:\n",
+		estimate.SampledGroups, estimate.SizeGroups, estimate.Fraction*100)
+	d.report("  duplicates:        ~%.0f ± %.0f files\n", estimate.Duplicates, estimate.DuplicatesMargin)
+	d.report("  reclaimable space: ~%.0f ± %.0f bytes\n", estimate.Bytes, estimate.BytesMargin)
+}
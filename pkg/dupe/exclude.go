@@ -0,0 +1,87 @@
+package dupe
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lixmal/finddupes/pkg/misc"
+)
+
+// exclude is a single entry of an exclude file
+type exclude struct {
+	pattern string
+	// glob entries are matched with filepath.Match, others are path prefixes
+	glob bool
+	// name entries are matched against the file name only
+	name bool
+}
+
+// loadExcludes reads the configured exclude file
+func (d *Dupe) loadExcludes() error {
+	if d.config.ExcludeFile == "" {
+		return nil
+	}
+
+	excludes, err := readExcludes(d.config.ExcludeFile)
+	if err != nil {
+		return fmt.Errorf("load excludes: %w", err)
+	}
+	d.excludes = excludes
+
+	return nil
+}
+
+// readExcludes parses a file of path prefixes and glob patterns, one per line.
+// Blank lines and lines starting with # are ignored.
+func readExcludes(path string) ([]exclude, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer misc.Close(path, f)
+
+	var excludes []exclude
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		ex := exclude{pattern: filepath.Clean(line), glob: strings.ContainsAny(line, `*?[`)}
+		if ex.glob {
+			if _, err := filepath.Match(ex.pattern, ""); err != nil {
+				return nil, fmt.Errorf("%s:%d: '%s': %w", path, n, line, err)
+			}
+			ex.name = !strings.ContainsRune(ex.pattern, filepath.Separator)
+		}
+		excludes = append(excludes, ex)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return excludes, nil
+}
+
+// excluded reports whether the path matches an entry of the exclude file
+func (d *Dupe) excluded(path, name string) bool {
+	for _, ex := range d.excludes {
+		switch {
+		case ex.name:
+			if ok, _ := filepath.Match(ex.pattern, name); ok {
+				return true
+			}
+		case ex.glob:
+			if ok, _ := filepath.Match(ex.pattern, filepath.Clean(path)); ok {
+				return true
+			}
+		case misc.UnderRoot(path, ex.pattern):
+			return true
+		}
+	}
+	return false
+}
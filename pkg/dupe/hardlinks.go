@@ -0,0 +1,60 @@
+package dupe
+
+import (
+	"github.com/lixmal/finddupes/pkg/file"
+)
+
+// splitHardlinks separates candidates sharing their inode with an earlier candidate, so every inode is only read once.
+// The returned links map the first candidate of an inode to the other paths of it.
+// Size and modification time must match as well, in case the stored inode of a known file is outdated.
+func splitHardlinks(candidates file.Slice) (file.Slice, map[*file.File]file.Slice) {
+	first := make(map[file.Inode]*file.File)
+	links := make(map[*file.File]file.Slice)
+
+	unique := candidates[:0]
+	for _, fil := range candidates {
+		if fil.Stat == nil || fil.Stat.Nlink <= 1 {
+			unique = append(unique, fil)
+			continue
+		}
+
+		inode := fil.Stat.Inode()
+		if known, ok := first[inode]; ok && known.Size == fil.Size && known.MTime.Equal(fil.MTime) {
+			links[known] = append(links[known], fil)
+			continue
+		}
+		first[inode] = fil
+		unique = append(unique, fil)
+	}
+
+	return unique, links
+}
+
+// linkHashes gives the other paths of hashed inodes the hash of the path that was read.
+// Paths of inodes that couldn't be hashed are left as they are and hashed on the next run.
+func (d *Dupe) linkHashes(links map[*file.File]file.Slice) {
+	var batch []hashResult
+	for hashed, others := range links {
+		for _, fil := range others {
+			d.hashProgress(fil.Size)
+		}
+		if hashed.Hash == "" || hashed.Partial || hashed.HashKind != d.hashKind() {
+			continue
+		}
+
+		for _, fil := range others {
+			if fil.Hash == hashed.Hash && fil.HashKind == hashed.HashKind && !fil.Partial {
+				continue
+			}
+			if d.verbose() {
+				d.logf("  %s is a hardlink of %s, reusing hash\n", fil.Path, hashed.Path)
+			}
+			fil.PrefixHash = hashed.PrefixHash
+			fil.MimeType = hashed.MimeType
+			fil.Unverified = hashed.Unverified
+			batch = append(batch, hashResult{file: fil, hash: hashed.Hash})
+		}
+	}
+
+	d.addHashes(batch)
+}
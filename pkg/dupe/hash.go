@@ -0,0 +1,266 @@
+package dupe
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/cespare/xxhash"
+	"github.com/lixmal/finddupes/pkg/config"
+	"github.com/lixmal/finddupes/pkg/file"
+	"github.com/lixmal/finddupes/pkg/misc"
+	"lukechampine.com/blake3"
+)
+
+const (
+	// sniffLen is the number of bytes considered for content type detection
+	sniffLen = 512
+	// bytesInterval is the number of bytes read between calls of the bytes callback
+	bytesInterval = 4 * 1024 * 1024
+	// hashBatchSize is the number of hashes a worker collects before adding them to the hashes table
+	hashBatchSize = 64
+)
+
+// hashFile calculates the hash of the file's content.
+// If enabled, the content type is detected from the same read.
+// On read errors while hashing, the hash of the content read before is returned along with the error.
+func (d *Dupe) hashFile(fil *file.File) (string, error) {
+	if d.config.NormalizeCmd != "" {
+		return d.hashCommand(fil)
+	}
+	if d.config.Fingerprinter != nil {
+		return d.hashFingerprint(fil)
+	}
+
+	f, err := d.openFile(fil)
+	if err != nil {
+		return "", err
+	}
+	defer misc.Close(fil.Path, f)
+
+	return d.hashContent(fil, f)
+}
+
+// hashContent calculates the hash of the file's content read from r, see hashFile
+func (d *Dupe) hashContent(fil *file.File, r io.Reader) (string, error) {
+	if d.limiter != nil {
+		r = &misc.RateLimitedReader{Ctx: d.ctx, Reader: r, Limiter: d.limiter}
+	}
+	if d.config.OnBytes != nil {
+		progress := &misc.ProgressReader{Reader: r, Interval: bytesInterval, Report: func(done int64) {
+			d.bytesProgress(fil, done)
+		}}
+		defer progress.Close()
+		r = progress
+	}
+
+	if d.config.DetectMime || d.config.NormalizeText {
+		buf := make([]byte, sniffLen)
+		n, err := io.ReadFull(r, buf)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return "", err
+		}
+		buf = buf[:n]
+
+		mimeType := detectMime(buf)
+		if d.config.DetectMime {
+			fil.MimeType = mimeType
+		}
+		r = io.MultiReader(bytes.NewReader(buf), r)
+
+		// binary files are hashed as they are
+		if d.config.NormalizeText && strings.HasPrefix(mimeType, "text/") {
+			r = newTextReader(r)
+		}
+	}
+
+	if d.config.DetectMoves {
+		buf, err := readPrefix(r)
+		if err != nil {
+			return "", err
+		}
+		if fil.PrefixHash, err = d.hashReader(bytes.NewReader(buf)); err != nil {
+			return "", err
+		}
+		r = io.MultiReader(bytes.NewReader(buf), r)
+	}
+
+	if d.config.PrefixOnly {
+		r = io.LimitReader(r, d.config.PrefixBytes)
+	}
+
+	return d.hashReader(r)
+}
+
+// hashFingerprint hashes the fingerprint of the file returned by the configured fingerprinter
+func (d *Dupe) hashFingerprint(fil *file.File) (string, error) {
+	if d.config.DetectMime {
+		mimeType, err := d.sniffMime(fil)
+		if err != nil {
+			return "", err
+		}
+		fil.MimeType = mimeType
+	}
+
+	fingerprint, err := d.config.Fingerprinter(fil.Path)
+	if err != nil {
+		return "", fmt.Errorf("fingerprint '%s': %w", fil.Path, err)
+	}
+
+	return d.hashReader(strings.NewReader(fingerprint))
+}
+
+// normalized reports whether files are compared by a domain specific equality instead of their content,
+// so duplicates may differ in size
+func (d *Dupe) normalized() bool {
+	return d.config.NormalizeCmd != "" || d.config.Fingerprinter != nil || d.config.NormalizeText
+}
+
+// sizeShortcut reports whether only files sharing their size with another one need to be hashed.
+// Normalized content doesn't depend on the size.
+func (d *Dupe) sizeShortcut() bool {
+	return !d.config.HashAll && !d.normalized()
+}
+
+// hashCommand hashes the output of the normalize command run for the file.
+// The command runs on the OS filesystem, with {} in its arguments replaced by the path.
+func (d *Dupe) hashCommand(fil *file.File) (string, error) {
+	args := strings.Fields(d.config.NormalizeCmd)
+	for i, arg := range args {
+		args[i] = strings.ReplaceAll(arg, "{}", fil.Path)
+	}
+
+	if d.config.DetectMime {
+		mimeType, err := d.sniffMime(fil)
+		if err != nil {
+			return "", err
+		}
+		fil.MimeType = mimeType
+	}
+
+	cmd := exec.CommandContext(d.ctx, args[0], args[1:]...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", fmt.Errorf("normalize '%s': %w", fil.Path, err)
+	}
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("normalize '%s': %w", fil.Path, err)
+	}
+
+	hash, err := d.hashReader(stdout)
+	if err != nil {
+		// don't leave the process behind
+		_ = cmd.Wait()
+		return "", fmt.Errorf("normalize '%s': %w", fil.Path, err)
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return "", fmt.Errorf("normalize '%s': %w", fil.Path, err)
+	}
+
+	return hash, nil
+}
+
+// hashKind returns the kind of hashes calculated with the current configuration.
+// Hashes of different kinds are never compared.
+func (d *Dupe) hashKind() string {
+	var mode string
+	switch {
+	case d.config.NormalizeCmd != "":
+		mode = file.HashKindCommand + d.config.NormalizeCmd
+	case d.config.Fingerprinter != nil:
+		mode = file.HashKindFingerprint
+	case d.config.NormalizeText:
+		mode = file.HashKindText
+	case d.config.PrefixOnly:
+		mode = file.HashKindPrefix + strconv.FormatInt(d.config.PrefixBytes, 10)
+	}
+
+	switch {
+	case d.algorithmKind == "":
+		return mode
+	case mode == "":
+		return d.algorithmKind
+	}
+	return d.algorithmKind + "+" + mode
+}
+
+// newHasher returns the constructor of the configured hash algorithm and its kind, empty for xxhash
+func newHasher(conf config.Config) (func() hash.Hash, string) {
+	if conf.HashAlgorithm != config.HashAlgorithmBlake3 {
+		return func() hash.Hash { return xxhash.New() }, ""
+	}
+	if len(conf.HashKey) == 0 {
+		return func() hash.Hash { return blake3.New(32, nil) }, file.HashKindBlake3
+	}
+
+	// identifies the key without revealing it
+	key := conf.HashKey
+	id := blake3.Sum256(key)
+	return func() hash.Hash { return blake3.New(32, key) }, file.HashKindBlake3 + file.HashKindKeyed + hex.EncodeToString(id[:8])
+}
+
+// hashReader calculates the hash of everything read from r with the configured algorithm.
+// On a read error, the hash of the bytes read before is returned along with the error.
+func (d *Dupe) hashReader(r io.Reader) (string, error) {
+	sum, _, err := misc.ReadHash(d.newHash(), r)
+	return sum, err
+}
+
+// openFile opens the file for reading, archive entries are read from their archive.
+// It waits for a slot of the open files budget first, which is released on close.
+func (d *Dupe) openFile(fil *file.File) (io.ReadCloser, error) {
+	if !d.acquireFile() {
+		return nil, ErrProcessStopped
+	}
+
+	var f io.ReadCloser
+	var err error
+	if fil.Archive != "" {
+		f, err = d.openEntry(fil)
+	} else {
+		f, err = d.fs.Open(fil.Path)
+	}
+	if err != nil {
+		d.releaseFile()
+		return nil, err
+	}
+	if d.openFiles == nil {
+		return f, nil
+	}
+	return &budgetedFile{ReadCloser: f, d: d}, nil
+}
+
+// sniffMime detects the content type of the file
+func (d *Dupe) sniffMime(fil *file.File) (string, error) {
+	f, err := d.openFile(fil)
+	if err != nil {
+		return "", err
+	}
+	defer misc.Close(fil.Path, f)
+
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+
+	return detectMime(buf[:n]), nil
+}
+
+// detectMime returns the media type of the content without parameters
+func detectMime(buf []byte) string {
+	contentType := http.DetectContentType(buf)
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mediaType
+	}
+	return contentType
+}
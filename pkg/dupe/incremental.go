@@ -0,0 +1,92 @@
+package dupe
+
+import (
+	"io/fs"
+	"log"
+	"path/filepath"
+
+	"github.com/lixmal/finddupes/pkg/database"
+	"github.com/lixmal/finddupes/pkg/file"
+	"github.com/lixmal/finddupes/pkg/misc"
+)
+
+// visitDir records the directory for incremental walks and skips it if its mtime is unchanged since the last run.
+// The mtime of a directory only changes if entries are added or removed, files within are known from the database.
+// Changes of subdirectories don't affect the mtime, so they are walked separately.
+func (d *Dupe) visitDir(path string, entry fs.DirEntry) error {
+	info, err := entry.Info()
+	if err != nil {
+		// walk as usual
+		return nil
+	}
+	d.visitedDirs[path] = struct{}{}
+
+	// the parent was walked, so it has to list this directory
+	if parent := filepath.Dir(path); parent != path && d.rewalkedDirs[parent] {
+		dir := d.database.Dirs[parent]
+		dir.Subdirs = append(dir.Subdirs, path)
+		d.database.Dirs[parent] = dir
+		d.database.MarkDirty()
+	}
+
+	stored, known := d.database.Dirs[path]
+	if !known || !stored.MTime.Equal(info.ModTime()) {
+		d.database.Dirs[path] = database.Dir{MTime: info.ModTime()}
+		d.database.MarkDirty()
+		d.rewalkedDirs[path] = true
+		return nil
+	}
+
+	if d.verbose() {
+		d.logf("Skipping unchanged directory %s\n", path)
+	}
+	d.markSeenDir(path)
+	for _, sub := range stored.Subdirs {
+		if err := misc.WalkDir(d.fs, sub, d.walkDir); err == ErrProcessStopped {
+			return err
+		} else if err != nil {
+			log.Println(err)
+		}
+	}
+
+	return filepath.SkipDir
+}
+
+// pruneDirs removes the records of directories below the roots that weren't found while walking
+func (d *Dupe) pruneDirs(roots []string) {
+	for path := range d.database.Dirs {
+		if _, visited := d.visitedDirs[path]; visited {
+			continue
+		}
+
+		for _, root := range roots {
+			if misc.UnderRoot(path, root) {
+				if d.verbose() {
+					d.logf("Directory %s vanished, removing\n", path)
+				}
+				delete(d.database.Dirs, path)
+				d.database.MarkDirty()
+				break
+			}
+		}
+	}
+}
+
+// markSeenDir records the current run on the known files of a skipped directory, they weren't walked but still exist.
+// Files changed since are verified separately.
+func (d *Dupe) markSeenDir(path string) {
+	if d.config.RunID == "" {
+		return
+	}
+
+	if d.dirFiles == nil {
+		d.dirFiles = map[string][]*file.File{}
+		for _, fil := range d.paths {
+			dir := filepath.Dir(fil.Path)
+			d.dirFiles[dir] = append(d.dirFiles[dir], fil)
+		}
+	}
+	for _, fil := range d.dirFiles[path] {
+		d.markSeen(fil)
+	}
+}
@@ -0,0 +1,81 @@
+package dupe
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"sync"
+
+	"github.com/lixmal/finddupes/pkg/file"
+	"github.com/lixmal/finddupes/pkg/workerpool"
+)
+
+// VerifyIntegrity reads the database and hashes all hashed files again whose mtime didn't change since.
+// It returns the files whose content changed anyway, e.g. by bit rot, sorted by path.
+// Files that can't be read are logged and recorded as errors. Neither the database nor any file is modified.
+func (d *Dupe) VerifyIntegrity() (file.Slice, error) {
+	if d.config.Path == "" {
+		return nil, fmt.Errorf("verify integrity: %w", ErrNoDatabase)
+	}
+	if err := d.ReadDatabase(); err != nil {
+		return nil, fmt.Errorf("verify integrity: %w", err)
+	}
+
+	var mutex sync.Mutex
+	var changed file.Slice
+	pool := workerpool.New(d.ctx, d.config.Workers, d.config.QueueDepth, func(_ int, fil *file.File) {
+		if d.corrupted(fil) {
+			mutex.Lock()
+			changed = append(changed, fil)
+			mutex.Unlock()
+		}
+	})
+
+	var err error
+	for _, files := range d.database.Files {
+		for _, fil := range files {
+			// hashes calculated differently can't be compared, archive entries have no mtime on the filesystem
+			if fil.Hash == "" || fil.Partial || fil.HashKind != d.hashKind() || fil.Archive != "" {
+				continue
+			}
+			if err = pool.Submit(fil); err != nil {
+				err = ErrProcessStopped
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	pool.Close()
+
+	return changed.SortByPath(), err
+}
+
+// corrupted reports whether the content of the file changed although its mtime didn't
+func (d *Dupe) corrupted(fil *file.File) bool {
+	info, err := fs.Stat(d.fs, fil.Path)
+	if err != nil {
+		log.Println(err)
+		d.addError(&HashError{Path: fil.Path, Err: err})
+		return false
+	}
+	// changed on purpose, hashed again on the next run
+	if !info.ModTime().Equal(fil.MTime) {
+		return false
+	}
+
+	if d.verbose() {
+		d.logf("  Verifying %s\n", fil.Path)
+	}
+	// hashing records details like the content type on the file, keep the stored one untouched
+	check := *fil
+	hash, err := d.hashWithRetries(&check)
+	if err != nil {
+		log.Println(err)
+		d.addError(&HashError{Path: fil.Path, Err: err})
+		return false
+	}
+
+	return hash != fil.Hash
+}
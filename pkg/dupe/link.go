@@ -0,0 +1,181 @@
+package dupe
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/lixmal/finddupes/pkg/config"
+	"github.com/lixmal/finddupes/pkg/file"
+	"github.com/lixmal/finddupes/pkg/trash"
+)
+
+// removeDuplicate deletes the duplicate or replaces it with a link to the survivor, depending on the link mode.
+// It reports whether the duplicate was removed, it's kept if skipped or on errors.
+func (d *Dupe) removeDuplicate(fil, survivor *file.File) bool {
+	switch d.config.LinkMode {
+	case config.LinkModeHardlink:
+		// e.g. duplicates of reference hash lists
+		if survivor == nil {
+			d.reportRemoval(fil, "no local file to link to, skipping\n")
+			return false
+		}
+		if d.config.LinkSameDevOnly && !sameDevice(fil, survivor) {
+			if !d.config.LinkFallbackDelete {
+				d.reportRemoval(fil, "not on the same device as %s, skipping\n", survivor.Path)
+				return false
+			}
+			d.reportRemoval(fil, "not on the same device as %s\n", survivor.Path)
+			return d.deleteFile(fil)
+		}
+		return d.hardlinkFile(fil, survivor)
+	case config.LinkModeStub:
+		return d.stubFile(fil)
+	case config.LinkModeTrash:
+		return d.trashFile(fil)
+	default:
+		return d.deleteFile(fil)
+	}
+}
+
+// hardlinkFile replaces the duplicate with a hard link to the survivor
+func (d *Dupe) hardlinkFile(fil, survivor *file.File) bool {
+	// last line of defense, rules have been checked before
+	if !d.deleteAllowed(fil.Path) {
+		d.reportRemoval(fil, "outside of allowed roots, not hardlinking\n")
+		return false
+	}
+
+	d.reportRemoval(fil, "hardlinking to %s...\n", survivor.Path)
+
+	if err := replaceWithLink(survivor.Path, fil.Path); err != nil {
+		d.reportRemoval(fil, "error hardlinking %s\n", err)
+		d.addError(&DeleteError{Path: fil.Path, Err: err})
+		return false
+	}
+
+	// the path now refers to the survivor's inode, update to avoid rehashing on the next run
+	info, err := os.Lstat(fil.Path)
+	if err != nil {
+		d.reportRemoval(fil, "error hardlinking %s\n", err)
+		return true
+	}
+	fil.MTime = info.ModTime()
+	fil.Mode = info.Mode()
+	fil.Stat = file.NewStat(info)
+	d.database.MarkDirty()
+	return true
+}
+
+// stubFile replaces the duplicate with an empty file
+func (d *Dupe) stubFile(fil *file.File) bool {
+	// last line of defense, rules have been checked before
+	if !d.deleteAllowed(fil.Path) {
+		d.reportRemoval(fil, "outside of allowed roots, not replacing\n")
+		return false
+	}
+
+	d.reportRemoval(fil, "replacing with empty file...\n")
+	info, err := replaceWithEmpty(fil.Path, fil.Mode.Perm())
+	if err != nil {
+		d.reportRemoval(fil, "error replacing %s\n", err)
+		d.addError(&DeleteError{Path: fil.Path, Err: err})
+		return false
+	}
+
+	// record the stub as a new file, it's no duplicate anymore
+	stub := &file.File{Path: fil.Path, Size: 0, MTime: info.ModTime(), Mode: info.Mode(), Stat: file.NewStat(info), Root: fil.Root, Reference: fil.Reference}
+	d.database.Lock()
+	d.database.Remove(fil)
+	d.database.Add(stub)
+	d.database.Unlock()
+	return true
+}
+
+// trashFile moves the duplicate to the trash
+func (d *Dupe) trashFile(fil *file.File) bool {
+	// last line of defense, rules have been checked before
+	if !d.deleteAllowed(fil.Path) {
+		d.reportRemoval(fil, "outside of allowed roots, not trashing\n")
+		return false
+	}
+
+	d.reportRemoval(fil, "moving to trash...\n")
+	if _, err := trash.Move(fil.Path); err != nil {
+		d.reportRemoval(fil, "error trashing %s\n", err)
+		d.addError(&DeleteError{Path: fil.Path, Err: err})
+		return false
+	}
+
+	d.database.Lock()
+	d.deletedDirs[filepath.Dir(fil.Path)] = struct{}{}
+	d.database.Remove(fil)
+	d.database.Unlock()
+	return true
+}
+
+// replaceWithEmpty atomically replaces path with an empty file of the given permissions.
+// A new file is created instead of truncating, so other hard links of the duplicate keep their content.
+func replaceWithEmpty(path string, perm os.FileMode) (os.FileInfo, error) {
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.finddupes-%d", filepath.Base(path), os.Getpid()))
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return nil, err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		if err2 := os.Remove(tmp); err2 != nil {
+			return nil, fmt.Errorf("%w, removing temporary file: %s", err, err2)
+		}
+		return nil, err
+	}
+
+	return os.Lstat(path)
+}
+
+// replaceWithLink atomically replaces path with a hard link to target
+func replaceWithLink(target, path string) error {
+	if sameFile(target, path) {
+		return nil
+	}
+
+	// link to a temporary name first, so the duplicate is never missing
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.finddupes-%d", filepath.Base(path), os.Getpid()))
+	if err := os.Link(target, tmp); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		if err2 := os.Remove(tmp); err2 != nil {
+			return fmt.Errorf("%w, removing temporary link: %s", err, err2)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// sameFile reports whether both paths refer to the same inode
+func sameFile(a, b string) bool {
+	infoA, err := os.Lstat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Lstat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(infoA, infoB)
+}
+
+// sameDevice reports whether both files are known to reside on the same device
+func sameDevice(a, b *file.File) bool {
+	if a.Stat == nil || b.Stat == nil {
+		return false
+	}
+	return a.Stat.Dev == b.Stat.Dev
+}
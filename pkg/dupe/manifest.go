@@ -0,0 +1,111 @@
+package dupe
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lixmal/finddupes/pkg/misc"
+)
+
+// loadManifest reads the configured manifest of known hashes
+func (d *Dupe) loadManifest() error {
+	if d.config.HashManifest == "" {
+		return nil
+	}
+
+	manifest, mtime, err := readManifest(d.config.HashManifest, d.newHash().Size())
+	if err != nil {
+		return fmt.Errorf("load manifest: %w", err)
+	}
+	d.manifest = manifest
+	d.manifestTime = mtime
+
+	return nil
+}
+
+// readManifest parses a file of hex encoded hashes and paths, one "hash  path" pair per line as written by
+// checksum tools, and returns the raw hashes by path along with the modification time of the manifest.
+// Blank lines and lines starting with # are ignored.
+func readManifest(path string, size int) (map[string]string, time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer misc.Close(path, f)
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	manifest := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		hexHash, filePath, ok := strings.Cut(line, " ")
+		// the second separator is a space, or * for files read in binary mode
+		filePath = strings.TrimPrefix(strings.TrimPrefix(filePath, " "), "*")
+		if !ok || filePath == "" {
+			return nil, time.Time{}, fmt.Errorf("%s:%d: expected 'hash  path'", path, n)
+		}
+
+		hash, err := hex.DecodeString(hexHash)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		if len(hash) != size {
+			return nil, time.Time{}, fmt.Errorf("%s:%d: hash is %d bytes long, the configured algorithm has %d", path, n, len(hash), size)
+		}
+
+		manifest[filepath.Clean(filePath)] = string(hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	return manifest, info.ModTime(), nil
+}
+
+// applyManifest takes the hashes of unhashed files over from the manifest.
+// Files modified after the manifest was written are hashed as usual.
+func (d *Dupe) applyManifest() {
+	if d.manifest == nil {
+		return
+	}
+
+	applied := 0
+	for _, files := range d.database.Files {
+		for _, fil := range files {
+			if fil.Hash != "" && fil.HashKind == d.hashKind() && !fil.Partial {
+				continue
+			}
+			hash, ok := d.manifest[fil.Path]
+			if !ok || fil.MTime.After(d.manifestTime) {
+				continue
+			}
+
+			if fil.Hash != "" {
+				delete(d.database.Hashes[fil.HashKey()], fil.Path)
+			}
+			fil.Hash = hash
+			fil.HashKind = d.hashKind()
+			fil.Partial = false
+			fil.Unverified = true
+			d.database.AddHash(fil)
+			applied++
+		}
+	}
+
+	if d.verbose() {
+		d.logf("Took %d hashes over from manifest\n", applied)
+	}
+}
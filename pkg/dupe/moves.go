@@ -0,0 +1,85 @@
+package dupe
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+
+	"github.com/lixmal/finddupes/pkg/file"
+	"github.com/lixmal/finddupes/pkg/misc"
+)
+
+// moveCheckLen is the number of bytes hashed to tell whether a new file is a moved one
+const moveCheckLen = 64 * 1024
+
+// rememberVanished records a file removed from the database as a possible source of a move
+func (d *Dupe) rememberVanished(fil *file.File) {
+	if !d.config.DetectMoves || fil.Hash == "" || fil.PrefixHash == "" || fil.Partial || fil.Archive != "" {
+		return
+	}
+
+	if d.vanished == nil {
+		d.vanished = map[int64]file.Slice{}
+	}
+	d.vanished[fil.Size] = append(d.vanished[fil.Size], fil)
+}
+
+// movedFile returns the vanished file the new file was moved from, or nil if there's none.
+// A file counts as moved if it's the same inode, the size, mtime and hash of the first bytes match,
+// and it was hashed the same way. Files sharing only a header are never taken for moved, as a rename keeps the inode.
+func (d *Dupe) movedFile(fil *file.File) *file.File {
+	if fil.Hash != "" || fil.Archive != "" || fil.Stat == nil || d.normalized() {
+		return nil
+	}
+
+	var candidates file.Slice
+	for _, vanished := range d.vanished[fil.Size] {
+		if vanished.Stat != nil && vanished.Stat.Inode() == fil.Stat.Inode() &&
+			vanished.MTime.Equal(fil.MTime) && vanished.HashKind == d.hashKind() {
+			candidates = append(candidates, vanished)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	prefixHash, err := d.prefixHash(fil)
+	if err != nil {
+		// hashed completely later on, which reports the error
+		log.Printf("Warning: failed to check if '%s' was moved: %s\n", fil.Path, err)
+		return nil
+	}
+
+	for _, vanished := range candidates {
+		if vanished.PrefixHash == prefixHash {
+			return vanished
+		}
+	}
+	return nil
+}
+
+// prefixHash hashes the first bytes of the file, as recorded to detect moves
+func (d *Dupe) prefixHash(fil *file.File) (string, error) {
+	f, err := d.openFile(fil)
+	if err != nil {
+		return "", err
+	}
+	defer misc.Close(fil.Path, f)
+
+	buf, err := readPrefix(f)
+	if err != nil {
+		return "", err
+	}
+	return d.hashReader(bytes.NewReader(buf))
+}
+
+// readPrefix reads the bytes hashed to detect moves, files may be shorter
+func readPrefix(r io.Reader) ([]byte, error) {
+	buf := make([]byte, moveCheckLen)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	return buf[:n], nil
+}
@@ -0,0 +1,59 @@
+package dupe
+
+import (
+	"io"
+	"sync"
+	"syscall"
+)
+
+// defaultMaxOpenFiles returns the number of files opened concurrently for hashing if not configured:
+// half the soft limit of open file descriptors, leaving the rest to the database, directories and the runtime.
+// Unlimited or unknown limits return 0, no budget.
+func defaultMaxOpenFiles() int {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return 0
+	}
+	// also covers RLIM_INFINITY
+	if limit.Cur > 1<<20 {
+		return 0
+	}
+	if limit.Cur < 2 {
+		return 1
+	}
+	return int(limit.Cur / 2)
+}
+
+// acquireFile waits for a slot of the open files budget, if any.
+// It returns false if processing was stopped while waiting.
+func (d *Dupe) acquireFile() bool {
+	if d.openFiles == nil {
+		return true
+	}
+
+	select {
+	case d.openFiles <- struct{}{}:
+		return true
+	case <-d.ctx.Done():
+		return false
+	}
+}
+
+func (d *Dupe) releaseFile() {
+	if d.openFiles != nil {
+		<-d.openFiles
+	}
+}
+
+// budgetedFile releases its slot of the open files budget once closed
+type budgetedFile struct {
+	io.ReadCloser
+	release sync.Once
+	d       *Dupe
+}
+
+func (f *budgetedFile) Close() error {
+	err := f.ReadCloser.Close()
+	f.release.Do(f.d.releaseFile)
+	return err
+}
@@ -0,0 +1,127 @@
+package dupe
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"runtime"
+
+	"github.com/lixmal/finddupes/pkg/config"
+)
+
+// Option changes the configuration built by NewWithOptions
+type Option func(*config.Config)
+
+// NewWithOptions creates a Dupe from the default configuration changed by the given options.
+// By default, a worker per CPU is used and duplicates are only reported.
+// The configuration is validated, see config.Config.Validate, and only one rule keeping a single file is allowed.
+func NewWithOptions(opts ...Option) (*Dupe, error) {
+	conf := config.Config{
+		Workers: runtime.NumCPU(),
+	}
+	for _, opt := range opts {
+		opt(&conf)
+	}
+
+	if err := conf.Validate(); err != nil {
+		return nil, fmt.Errorf("new: %w", err)
+	}
+	// New applies one of them by precedence for compatibility
+	if n := conf.SurvivorRules(); n > 1 {
+		return nil, fmt.Errorf("new: %d rules keeping a single file given, only one is allowed", n)
+	}
+
+	return New(conf), nil
+}
+
+// WithConfig replaces the whole configuration, options given after it change it further
+func WithConfig(conf config.Config) Option {
+	return func(c *config.Config) {
+		*c = conf
+	}
+}
+
+// WithWorkers sets the number of files hashed in parallel
+func WithWorkers(n int) Option {
+	return func(c *config.Config) {
+		c.Workers = n
+	}
+}
+
+// WithQueueDepth sets the number of files queued for hashing
+func WithQueueDepth(n int) Option {
+	return func(c *config.Config) {
+		c.QueueDepth = n
+	}
+}
+
+// WithDatabase reads and writes the database at path
+func WithDatabase(path string) Option {
+	return func(c *config.Config) {
+		c.Path = path
+	}
+}
+
+// WithDelete deletes the duplicates matching the rules instead of only reporting them
+func WithDelete() Option {
+	return func(c *config.Config) {
+		c.Delete = true
+	}
+}
+
+// WithKeepFirst keeps the lexically first file of each group
+func WithKeepFirst() Option {
+	return func(c *config.Config) {
+		c.KeepFirst = true
+	}
+}
+
+// WithKeepLast keeps the lexically last file of each group
+func WithKeepLast() Option {
+	return func(c *config.Config) {
+		c.KeepLast = true
+	}
+}
+
+// WithKeepOldest keeps the file of each group with the oldest mtime
+func WithKeepOldest() Option {
+	return func(c *config.Config) {
+		c.KeepOldest = true
+	}
+}
+
+// WithKeepRecent keeps the file of each group with the most recent mtime
+func WithKeepRecent() Option {
+	return func(c *config.Config) {
+		c.KeepRecent = true
+	}
+}
+
+// WithDelMatch deletes duplicates whose path matches re
+func WithDelMatch(re *regexp.Regexp) Option {
+	return func(c *config.Config) {
+		c.DelMatch = re
+	}
+}
+
+// WithKeepMatch deletes duplicates whose path doesn't match re
+func WithKeepMatch(re *regexp.Regexp) Option {
+	return func(c *config.Config) {
+		c.KeepMatch = re
+	}
+}
+
+// WithReference never deletes files below the paths, but their duplicates elsewhere
+func WithReference(paths ...string) Option {
+	return func(c *config.Config) {
+		c.ReferencePaths = append(c.ReferencePaths, paths...)
+	}
+}
+
+// WithOutput writes all messages to w in the given format, see config.OutputText and others
+func WithOutput(w io.Writer, format string) Option {
+	return func(c *config.Config) {
+		c.Output = w
+		c.OutputFormat = format
+	}
+}
@@ -0,0 +1,94 @@
+package dupe
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+
+	"github.com/lixmal/finddupes/pkg/file"
+)
+
+// owner holds the resolved ids of the preferred owner, nil ids match any file
+type owner struct {
+	uid *uint32
+	gid *uint32
+}
+
+// resolveOwner looks up the configured preferred user and group
+func (d *Dupe) resolveOwner() error {
+	if d.config.KeepUser == "" && d.config.KeepGroup == "" {
+		return nil
+	}
+
+	var o owner
+	if d.config.KeepUser != "" {
+		uid, err := lookupID(d.config.KeepUser, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return fmt.Errorf("resolve user '%s': %w", d.config.KeepUser, err)
+		}
+		o.uid = &uid
+	}
+
+	if d.config.KeepGroup != "" {
+		gid, err := lookupID(d.config.KeepGroup, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return fmt.Errorf("resolve group '%s': %w", d.config.KeepGroup, err)
+		}
+		o.gid = &gid
+	}
+
+	d.owner = &o
+	return nil
+}
+
+// lookupID resolves a name to its numeric id, names consisting of digits only are taken as id
+func lookupID(name string, lookup func(string) (string, error)) (uint32, error) {
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+
+	idStr, err := lookup(name)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("non numeric id '%s'", idStr)
+	}
+	return uint32(id), nil
+}
+
+// ownedFile reports whether the file is owned by the preferred owner
+func (d *Dupe) ownedFile(fil *file.File) bool {
+	if d.owner == nil {
+		return false
+	}
+	return d.owner.owns(fil.Stat)
+}
+
+// owns reports whether the stat matches the owner, unknown ownership never matches
+func (o *owner) owns(stat *file.Stat) bool {
+	if stat == nil {
+		return false
+	}
+	if o.uid != nil && stat.Uid != *o.uid {
+		return false
+	}
+	if o.gid != nil && stat.Gid != *o.gid {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,114 @@
+package dupe
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/lixmal/finddupes/pkg/file"
+)
+
+// partition splits a group of files with equal hashes into the groups that are considered duplicates.
+// Groups with less than two files are dropped. The order of files is kept.
+func (d *Dupe) partition(fileSlice file.Slice) []file.Slice {
+	var partitions []file.Slice
+	for _, group := range d.partitionByName(fileSlice) {
+		partitions = append(partitions, d.partitionByTime(group)...)
+	}
+	return partitions
+}
+
+// partitionByName splits the files by name, if same name matching is enabled
+func (d *Dupe) partitionByName(fileSlice file.Slice) []file.Slice {
+	if len(fileSlice) < 2 {
+		return nil
+	}
+	if !d.config.SameName {
+		return []file.Slice{fileSlice}
+	}
+
+	var keys []string
+	groups := map[string]file.Slice{}
+	for _, fil := range fileSlice {
+		key := d.nameKey(fil.Path)
+		if _, exists := groups[key]; !exists {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], fil)
+	}
+
+	var partitions []file.Slice
+	for _, key := range keys {
+		if len(groups[key]) >= 2 {
+			partitions = append(partitions, groups[key])
+		}
+	}
+	return partitions
+}
+
+// partitionByTime splits the files into clusters of modification times, if a time window is configured.
+// Files belong to the same cluster if their modification time is within the window of another file of the cluster.
+// With a strict window, clusters start with their oldest file and contain the files within the window of it.
+func (d *Dupe) partitionByTime(fileSlice file.Slice) []file.Slice {
+	if d.config.TimeWindow <= 0 {
+		return []file.Slice{fileSlice}
+	}
+
+	byTime := fileSlice.Clone().SortByTime(file.SortAscending)
+	cluster := map[*file.File]int{}
+	clusters := 0
+	start := 0
+	for i, fil := range byTime {
+		prev := i - 1
+		if d.config.StrictTimeWindow {
+			prev = start
+		}
+		if i > 0 && fil.MTime.Sub(byTime[prev].MTime) > d.config.TimeWindow {
+			clusters++
+			start = i
+		}
+		cluster[fil] = clusters
+	}
+
+	// keep the original order within the clusters
+	groups := make([]file.Slice, clusters+1)
+	for _, fil := range fileSlice {
+		groups[cluster[fil]] = append(groups[cluster[fil]], fil)
+	}
+
+	var partitions []file.Slice
+	for _, group := range groups {
+		if len(group) >= 2 {
+			partitions = append(partitions, group)
+		}
+	}
+	return partitions
+}
+
+// nameKey returns the base name of path with its extension canonicalized according to the configured aliases
+func (d *Dupe) nameKey(path string) string {
+	name := filepath.Base(path)
+	ext := filepath.Ext(name)
+	if ext == "" {
+		return name
+	}
+
+	canonical := normalizeExt(ext)
+	if alias, ok := d.extAliases[canonical]; ok {
+		canonical = alias
+	}
+	return strings.TrimSuffix(name, ext) + canonical
+}
+
+// normalizeExtAliases returns the aliases with all extensions normalized
+func normalizeExtAliases(aliases map[string]string) map[string]string {
+	normalized := make(map[string]string, len(aliases))
+	for ext, alias := range aliases {
+		normalized[normalizeExt(ext)] = normalizeExt(alias)
+	}
+	return normalized
+}
+
+// normalizeExt returns the extension in lower case with a leading dot
+func normalizeExt(ext string) string {
+	return "." + strings.ToLower(strings.TrimPrefix(ext, "."))
+}
@@ -0,0 +1,49 @@
+package dupe
+
+import (
+	"fmt"
+
+	"github.com/lixmal/finddupes/pkg/database"
+)
+
+// loadReferenceDBs merges the hashed files of the configured reference databases as reference files.
+// They are never deleted, verified or written back, only the files of the database at the configured path are.
+// Paths known locally or from an earlier reference database are taken from there.
+func (d *Dupe) loadReferenceDBs() error {
+	if len(d.config.ReferenceDBs) == 0 {
+		return nil
+	}
+
+	known := map[string]struct{}{}
+	for _, files := range d.database.Files {
+		for path := range files {
+			known[path] = struct{}{}
+		}
+	}
+
+	for _, path := range d.config.ReferenceDBs {
+		db := database.New()
+		if err := db.Read(path, d.config.DBFormat); err != nil {
+			return fmt.Errorf("load reference database '%s': %w", path, err)
+		}
+
+		added := 0
+		for _, files := range db.Files {
+			for _, fil := range files {
+				if _, exists := known[fil.Path]; exists || fil.Hash == "" || fil.Partial {
+					continue
+				}
+				known[fil.Path] = struct{}{}
+				fil.Reference = true
+				d.addTransient(fil)
+				added++
+			}
+		}
+
+		if d.verbose() {
+			d.logf("Loaded %d reference files from database '%s'\n", added, path)
+		}
+	}
+
+	return nil
+}
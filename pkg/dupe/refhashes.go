@@ -0,0 +1,81 @@
+package dupe
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lixmal/finddupes/pkg/file"
+	"github.com/lixmal/finddupes/pkg/misc"
+)
+
+// loadReferenceHashes adds placeholder reference files for the configured list of known hashes.
+// The placeholders don't exist on disk, they are never hashed, deleted or written to the database.
+func (d *Dupe) loadReferenceHashes() error {
+	if d.config.ReferenceHashes == "" {
+		return nil
+	}
+
+	refs, err := readReferenceHashes(d.config.ReferenceHashes, d.newHash().Size())
+	if err != nil {
+		return fmt.Errorf("load reference hashes: %w", err)
+	}
+
+	for _, fil := range refs {
+		fil.HashKind = d.hashKind()
+		d.addTransient(fil)
+		d.placeholders[fil] = struct{}{}
+	}
+
+	if d.verbose() {
+		d.logf("Loaded %d reference hashes\n", len(refs))
+	}
+	return nil
+}
+
+// readReferenceHashes parses a file of hex encoded hashes and sizes in bytes, one "hash size" pair per line.
+// Each pair becomes a reference file named after its position in the list, e.g. refs.txt:3.
+// Blank lines and lines starting with # are ignored.
+func readReferenceHashes(path string, size int) (file.Slice, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer misc.Close(path, f)
+
+	var refs file.Slice
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected 'hash size'", path, n)
+		}
+
+		hash, err := hex.DecodeString(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		if len(hash) != size {
+			return nil, fmt.Errorf("%s:%d: hash is %d bytes long, the configured algorithm has %d", path, n, len(hash), size)
+		}
+		fileSize, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || fileSize < 0 {
+			return nil, fmt.Errorf("%s:%d: invalid size '%s'", path, n, fields[1])
+		}
+
+		refs = append(refs, &file.File{Path: fmt.Sprintf("%s:%d", path, n), Hash: string(hash), Size: fileSize, Reference: true})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return refs, nil
+}
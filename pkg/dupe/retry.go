@@ -0,0 +1,55 @@
+package dupe
+
+import (
+	"errors"
+	"log"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/lixmal/finddupes/pkg/file"
+)
+
+// hashWithRetries hashes the file, retrying transient errors with a delay doubling on every attempt.
+// The error of the last attempt is returned if all attempts fail.
+func (d *Dupe) hashWithRetries(fil *file.File) (string, error) {
+	delay := d.config.HashRetryDelay
+	for attempt := 0; ; attempt++ {
+		hash, err := d.hashFile(fil)
+		if err == nil || attempt >= d.config.HashRetries || !retryable(err) {
+			return hash, err
+		}
+
+		log.Printf("Warning: hashing %s failed, retrying in %s: %s\n", fil.Path, delay, err)
+		select {
+		case <-d.ctx.Done():
+			return hash, err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// retryable reports whether the error might be transient, e.g. a timeout of a network filesystem.
+// Missing files and denied permissions are permanent.
+func retryable(err error) bool {
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+		return false
+	}
+
+	var timeout interface{ Timeout() bool }
+	if errors.As(err, &timeout) && timeout.Timeout() {
+		return true
+	}
+
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		switch errno {
+		case syscall.EIO, syscall.ETIMEDOUT, syscall.EAGAIN, syscall.EINTR, syscall.ESTALE,
+			syscall.ECONNRESET, syscall.EHOSTUNREACH, syscall.ENETUNREACH:
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,447 @@
+package dupe
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lixmal/finddupes/pkg/config"
+	"github.com/lixmal/finddupes/pkg/file"
+	"github.com/lixmal/finddupes/pkg/misc"
+)
+
+// decision is the outcome of the deletion rules for a single file of a duplicate group.
+// Kept files may carry a reason too, e.g. if they were vetoed.
+type decision struct {
+	file   *file.File
+	delete bool
+	reason string
+}
+
+// decide applies the deletion rules to a group of duplicates sorted by path.
+// At least one file of the group is always kept.
+// If the positional and pattern rules disagree on the file to keep, the positional survivor is returned as conflict.
+func (d *Dupe) decide(fileSlice file.Slice) (decisions []decision, conflict *file.File) {
+	d.refreshStat(fileSlice)
+
+	// the file to keep is never junk, if there's a choice
+	candidates := fileSlice
+	if junk := d.junkFiles(fileSlice); junk != nil {
+		candidates = nil
+		for _, fil := range fileSlice {
+			if !junk[fil] {
+				candidates = append(candidates, fil)
+			}
+		}
+	}
+	survivor, reason := d.survivor(candidates)
+
+	// reference files take precedence over all positional rules
+	if ref := referenceFile(fileSlice); ref != nil {
+		survivor, reason = ref, "duplicate of reference file "+ref.Path
+	} else if d.conflictingSurvivor(fileSlice, survivor) {
+		conflict = survivor
+	}
+
+	return d.decideSurvivor(fileSlice, survivor, reason), conflict
+}
+
+// conflictingSurvivor reports whether the positional rules keep a file the pattern rules would delete,
+// while the pattern rules keep another file of the group. Both files are kept then.
+func (d *Dupe) conflictingSurvivor(fileSlice file.Slice, survivor *file.File) bool {
+	if survivor == nil {
+		return false
+	}
+	if _, ok := d.patternMatch(survivor); !ok {
+		return false
+	}
+
+	for _, fil := range fileSlice {
+		if _, ok := d.patternMatch(fil); fil != survivor && !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// decideSurvivor applies the deletion rules to a group of duplicates, keeping the given survivor.
+// Hard links of the same inode are a single entry: they are deleted together if all of them match the rules,
+// and kept together otherwise, as deleting only some names frees no space.
+func (d *Dupe) decideSurvivor(fileSlice file.Slice, survivor *file.File, reason string) []decision {
+	decisions := make([]decision, len(fileSlice))
+	entries := linkEntries(fileSlice)
+	junk := d.junkFiles(fileSlice)
+
+	remaining := 0
+	for i, fil := range fileSlice {
+		decisions[i].file = fil
+		if entries[fil] == i {
+			remaining++
+		}
+	}
+
+	for i, fil := range fileSlice {
+		first := entries[fil]
+		// decided with the first link of the entry, all links of the survivor are kept
+		if first != i || survivor != nil && entries[survivor] == first {
+			continue
+		}
+
+		// no duplicates left
+		if remaining < 2 {
+			break
+		}
+
+		var matchReason string
+		deleteAll := true
+		for j := i; j < len(fileSlice); j++ {
+			link := fileSlice[j]
+			if entries[link] != first {
+				continue
+			}
+
+			linkReason, ok := d.matchRules(link, survivor, reason, junk[link])
+			if !ok {
+				deleteAll = false
+				break
+			}
+			if !d.deleteAllowed(link.Path) {
+				log.Printf("Not deleting '%s': outside of allowed roots\n", link.Path)
+				decisions[j].reason = linkReason + ", but outside of allowed roots"
+				deleteAll = false
+				break
+			}
+			if matchReason == "" {
+				matchReason = linkReason
+			}
+		}
+		if !deleteAll {
+			continue
+		}
+
+		for j := i; j < len(fileSlice); j++ {
+			if entries[fileSlice[j]] == first {
+				decisions[j].delete = true
+				decisions[j].reason = matchReason
+			}
+		}
+		// count even if deletion fails later, to be safe
+		remaining--
+	}
+
+	return decisions
+}
+
+// linkEntries maps each file of the group to the index of the first file with the same inode.
+// Files of unknown inodes, e.g. archive entries, are entries of their own.
+func linkEntries(fileSlice file.Slice) map[*file.File]int {
+	entries := make(map[*file.File]int, len(fileSlice))
+	first := map[file.Inode]int{}
+	for i, fil := range fileSlice {
+		entries[fil] = i
+		if fil.Stat == nil {
+			continue
+		}
+		if j, ok := first[fil.Stat.Inode()]; ok {
+			entries[fil] = j
+			continue
+		}
+		first[fil.Stat.Inode()] = i
+	}
+	return entries
+}
+
+// selectSurvivor lets the configured selector choose the file to keep and applies the deletion rules to the others.
+// Reference files and files vetoed by other rules are still kept.
+func (d *Dupe) selectSurvivor(fileSlice file.Slice) ([]decision, error) {
+	i, err := d.config.KeepSelector(fileSlice)
+	if err != nil {
+		return nil, fmt.Errorf("select file to keep: %w", err)
+	}
+
+	switch {
+	case i < 0:
+		// skipped, keep all
+		decisions := make([]decision, len(fileSlice))
+		for i, fil := range fileSlice {
+			decisions[i].file = fil
+		}
+		return decisions, nil
+	case i >= len(fileSlice):
+		return nil, fmt.Errorf("select file to keep: index %d out of range for %d files", i, len(fileSlice))
+	}
+
+	return d.decideSurvivor(fileSlice, fileSlice[i], "not selected"), nil
+}
+
+// checkConflicts warns about groups whose rules disagree on the file to keep.
+// With strict rules, it fails listing all of them before anything is deleted.
+func (d *Dupe) checkConflicts(plans []plan) error {
+	var conflicts []string
+	for _, p := range plans {
+		if p.conflict == nil {
+			continue
+		}
+		if d.config.StrictRules {
+			conflicts = append(conflicts, p.conflict.Path)
+			continue
+		}
+		log.Printf("Warning: rules disagree on the file to keep: %s is kept by position, but matches the pattern rules, which keep another file\n", p.conflict.Path)
+	}
+
+	if len(conflicts) > 0 {
+		return fmt.Errorf("%w in %d groups, kept by position: %s", ErrAmbiguousRules, len(conflicts), strings.Join(conflicts, ", "))
+	}
+	return nil
+}
+
+// keptFile returns the first file of the group that isn't deleted
+func keptFile(decisions []decision) *file.File {
+	for _, dec := range decisions {
+		if !dec.delete {
+			return dec.file
+		}
+	}
+	return nil
+}
+
+// deletesAny reports whether any file of the group is to be deleted
+func deletesAny(decisions []decision) bool {
+	for _, dec := range decisions {
+		if dec.delete {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRules reports whether the file should be deleted and why.
+// The survivor designated by the positional rules is never matched, the regex rules only apply to the remaining files.
+// Without regex rules, all files except the survivor are matched.
+func (d *Dupe) matchRules(fil, survivor *file.File, survivorReason string, junk bool) (string, bool) {
+	// reference files, devices, files of the preferred owner, tagged files and distinct empty files are never deleted
+	if fil == survivor || fil.Reference || fil.Mode&os.ModeType != 0 || d.ownedFile(fil) || d.taggedFile(fil) ||
+		fil.Size == 0 && d.config.EmptyDistinct {
+		return "", false
+	}
+
+	if junk {
+		return "matches junk regex", true
+	}
+
+	if reason, ok := d.patternMatch(fil); ok {
+		return reason, true
+	}
+	if survivor != nil && d.config.DelMatch == nil && d.config.KeepMatch == nil {
+		return survivorReason, true
+	}
+
+	return "", false
+}
+
+// junkFiles returns the files of the group matching the junk pattern, if some files of the group don't match it.
+// Otherwise it returns nil, junk files are only preferred for deletion if there's a copy that isn't junk.
+func (d *Dupe) junkFiles(fileSlice file.Slice) map[*file.File]bool {
+	if d.config.JunkMatch == nil {
+		return nil
+	}
+
+	junk := map[*file.File]bool{}
+	for _, fil := range fileSlice {
+		if d.config.JunkMatch.MatchString(d.matchPath(fil)) {
+			junk[fil] = true
+		}
+	}
+
+	if len(junk) == 0 || len(junk) == len(fileSlice) {
+		return nil
+	}
+	return junk
+}
+
+// refreshStat reads the current ownership, permissions and access times of the files of the group, if rules depend
+// on them. Changing them doesn't change the mtime, so the stored ones might be outdated. Access times of files hashed
+// in this run are kept, reading them for hashing changed them.
+// Files that can't be read, e.g. archive entries, keep the stored information.
+func (d *Dupe) refreshStat(fileSlice file.Slice) {
+	atime := d.config.KeepRecentAccess || d.config.KeepOldestAccess
+	if d.owner == nil && !d.config.KeepMostRestrictive && !d.config.KeepLeastRestrictive && !atime {
+		return
+	}
+
+	for _, fil := range fileSlice {
+		if fil.Archive != "" {
+			continue
+		}
+		info, err := fs.Stat(d.fs, fil.Path)
+		if err != nil {
+			continue
+		}
+
+		if info.Mode().Perm() != fil.Mode.Perm() {
+			fil.Mode = fil.Mode&^os.ModePerm | info.Mode().Perm()
+			d.database.MarkDirty()
+		}
+		stat := file.NewStat(info)
+		if stat == nil {
+			continue
+		}
+		if _, hashed := d.hashedFiles[fil]; fil.Stat != nil && (!atime || hashed) {
+			stat.ATime = fil.Stat.ATime
+		}
+		if fil.Stat == nil || fil.Stat.Uid != stat.Uid || fil.Stat.Gid != stat.Gid || !fil.Stat.ATime.Equal(stat.ATime) {
+			fil.Stat = stat
+			d.database.MarkDirty()
+		}
+	}
+}
+
+// patternMatch reports whether the pattern rules match the file for deletion and why
+func (d *Dupe) patternMatch(fil *file.File) (string, bool) {
+	switch {
+	case d.config.DelMatch != nil && d.config.DelMatch.MatchString(d.matchPath(fil)):
+		return "matches del regex", true
+	case d.config.KeepMatch != nil && !d.config.KeepMatch.MatchString(d.matchPath(fil)):
+		return "does not match keep regex", true
+	}
+
+	return "", false
+}
+
+// survivor returns the file designated to be kept by the positional rules and the reason for deleting all others.
+// Groups are sorted by path, so ties are always resolved by keeping the lexically first file.
+// It returns nil if no positional rule applies, except for normalized duplicates: they can differ in content,
+// so the largest file is kept then.
+func (d *Dupe) survivor(fileSlice file.Slice) (*file.File, string) {
+	switch {
+	case d.config.KeepRecent:
+		return fileSlice.Clone().SortByTime(file.SortDescending)[0], "not most recent entry"
+	case d.config.KeepOldest:
+		return fileSlice.Clone().SortByTime(file.SortAscending)[0], "not oldest entry"
+	case d.config.KeepRecentAccess:
+		return fileSlice.Clone().SortByAccessTime(file.SortDescending)[0], "not most recently accessed entry"
+	case d.config.KeepOldestAccess:
+		return fileSlice.Clone().SortByAccessTime(file.SortAscending)[0], "not least recently accessed entry"
+	case d.config.KeepLargestFile:
+		return fileSlice.Clone().SortBySize(file.SortDescending)[0], "not largest entry"
+	case d.config.KeepSmallestFile:
+		return fileSlice.Clone().SortBySize(file.SortAscending)[0], "not smallest entry"
+	case d.config.KeepMostRestrictive:
+		return fileSlice.Clone().SortByPermissions(file.SortAscending)[0], "not most restrictive permissions"
+	case d.config.KeepLeastRestrictive:
+		return fileSlice.Clone().SortByPermissions(file.SortDescending)[0], "not least restrictive permissions"
+	case d.config.KeepFirst:
+		return fileSlice[0], "not first entry"
+	case d.config.KeepLast:
+		return fileSlice[len(fileSlice)-1], "not last entry"
+	case len(d.config.KeepPriority) > 0:
+		if fil := d.prioritySurvivor(fileSlice); fil != nil {
+			return fil, "not highest priority entry"
+		}
+	case d.owner != nil:
+		for _, fil := range fileSlice {
+			if d.ownedFile(fil) {
+				return fil, "not owned by preferred owner"
+			}
+		}
+	case d.config.KeepTagged:
+		for _, fil := range fileSlice {
+			if d.taggedFile(fil) {
+				return fil, "not tagged"
+			}
+		}
+	}
+
+	if d.normalized() {
+		return fileSlice.Clone().SortBySize(file.SortDescending)[0], "not largest entry"
+	}
+	return nil, ""
+}
+
+// deleteAllowed reports whether the path is located below one of the roots deletion is allowed in.
+// Without any configured roots, deletion is allowed everywhere.
+func (d *Dupe) deleteAllowed(path string) bool {
+	if len(d.config.DeleteAllowedRoots) == 0 {
+		return true
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	for _, root := range d.config.DeleteAllowedRoots {
+		absRoot, err := filepath.Abs(root)
+		if err == nil && misc.UnderRoot(abs, absRoot) {
+			return true
+		}
+	}
+	return false
+}
+
+// taggedFile reports whether the file has the configured extended attributes.
+// Errors reading them are treated as untagged, which is safe as tagged files are only ever kept.
+func (d *Dupe) taggedFile(fil *file.File) bool {
+	if !d.config.KeepTagged || fil.Archive != "" {
+		return false
+	}
+
+	tagged, err := file.HasXattr(fil.Path, d.config.KeepTag)
+	if err != nil {
+		log.Printf("Warning: failed to read extended attributes of '%s': %s\n", fil.Path, err)
+		return false
+	}
+	return tagged
+}
+
+// referenceFile returns the first reference file of the group, or nil if there's none
+func referenceFile(fileSlice file.Slice) *file.File {
+	for _, fil := range fileSlice {
+		if fil.Reference {
+			return fil
+		}
+	}
+	return nil
+}
+
+// matchPath returns the path of the file in the form the patterns are matched against.
+// Files without a scanned path, e.g. of remote databases, are matched as stored.
+func (d *Dupe) matchPath(fil *file.File) string {
+	switch d.config.MatchTarget {
+	case config.MatchAbsolute:
+		abs, err := filepath.Abs(fil.Path)
+		if err != nil {
+			return fil.Path
+		}
+		return abs
+	case config.MatchRelative:
+		if fil.Root == "" {
+			return fil.Path
+		}
+		rel, err := filepath.Rel(fil.Root, fil.Path)
+		if err != nil {
+			return fil.Path
+		}
+		// the scanned path is the file itself
+		if rel == "." {
+			return filepath.Base(fil.Path)
+		}
+		return rel
+	}
+	return fil.Path
+}
+
+// prioritySurvivor returns the lexically first file matching the highest priority pattern.
+// It returns nil if no file matches any pattern.
+func (d *Dupe) prioritySurvivor(fileSlice file.Slice) *file.File {
+	for _, re := range d.config.KeepPriority {
+		for _, fil := range fileSlice {
+			if re.MatchString(d.matchPath(fil)) {
+				return fil
+			}
+		}
+	}
+	return nil
+}
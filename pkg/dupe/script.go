@@ -0,0 +1,89 @@
+package dupe
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lixmal/finddupes/pkg/config"
+	"github.com/lixmal/finddupes/pkg/file"
+)
+
+// openScript creates the shell script the removals are written to instead of executing them
+func (d *Dupe) openScript() error {
+	f, err := os.OpenFile(d.config.ScriptPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o755)
+	if err != nil {
+		return fmt.Errorf("create script: %w", err)
+	}
+
+	d.scriptFile = f
+	d.script = bufio.NewWriter(f)
+	fmt.Fprintf(d.script, "#!/bin/sh\n# generated by finddupes, review before running\nset -e\n")
+
+	return nil
+}
+
+// closeScript flushes and closes the script, reporting any write error
+func (d *Dupe) closeScript() error {
+	defer func() {
+		d.script = nil
+		d.scriptFile = nil
+	}()
+
+	if err := d.script.Flush(); err != nil {
+		d.scriptFile.Close()
+		return fmt.Errorf("write script: %w", err)
+	}
+	if err := d.scriptFile.Close(); err != nil {
+		return fmt.Errorf("write script: %w", err)
+	}
+
+	return nil
+}
+
+// scriptGroup writes a comment introducing a group of duplicates
+func (d *Dupe) scriptGroup(fileSlice file.Slice, survivor *file.File) {
+	if d.script == nil {
+		return
+	}
+
+	fmt.Fprintf(d.script, "\n# %d elements for hash %s", len(fileSlice), fileSlice[0].HashString())
+	if survivor != nil {
+		// quoted, a newline in the path would end the comment
+		fmt.Fprintf(d.script, ", keeping %s", strconv.Quote(survivor.Path))
+	}
+	fmt.Fprintln(d.script)
+}
+
+// scriptRemoval writes the command removing the duplicate according to the link mode
+func (d *Dupe) scriptRemoval(fil, survivor *file.File) {
+	if d.script == nil {
+		return
+	}
+
+	if d.config.LinkMode == config.LinkModeHardlink {
+		if survivor == nil {
+			fmt.Fprintf(d.script, "# no local file to link %s to\n", strconv.Quote(fil.Path))
+			return
+		}
+		fmt.Fprintf(d.script, "ln -f -- %s %s\n", shellQuote(survivor.Path), shellQuote(fil.Path))
+		return
+	}
+	if d.config.LinkMode == config.LinkModeStub {
+		// remove first, truncating would empty other hard links too
+		fmt.Fprintf(d.script, "rm -- %s && : > %s\n", shellQuote(fil.Path), shellQuote(fil.Path))
+		return
+	}
+	if d.config.LinkMode == config.LinkModeTrash {
+		fmt.Fprintf(d.script, "gio trash -- %s\n", shellQuote(fil.Path))
+		return
+	}
+	fmt.Fprintf(d.script, "rm -- %s\n", shellQuote(fil.Path))
+}
+
+// shellQuote quotes s in single quotes for POSIX shells, nothing inside is interpreted
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,34 @@
+package dupe
+
+import (
+	"time"
+
+	"github.com/lixmal/finddupes/pkg/file"
+)
+
+// WorkerStats are the hashing statistics of a single worker
+type WorkerStats struct {
+	// Files is the number of files hashed
+	Files int
+	// Bytes is the size of all files hashed
+	Bytes int64
+	// Busy is the time spent hashing
+	Busy time.Duration
+}
+
+// Stats returns the hashing statistics of every worker of the last hashing run, indexed by worker.
+// It must not be called while hashing.
+func (d *Dupe) Stats() []WorkerStats {
+	stats := make([]WorkerStats, len(d.workerStats))
+	copy(stats, d.workerStats)
+	return stats
+}
+
+// countHash adds a hashed file to the statistics of the worker.
+// Every worker only writes its own entry, so no locking is needed.
+func (d *Dupe) countHash(worker int, fil *file.File, start time.Time) {
+	stats := &d.workerStats[worker]
+	stats.Files++
+	stats.Bytes += fil.Size
+	stats.Busy += time.Since(start)
+}
@@ -0,0 +1,55 @@
+package dupe
+
+import "github.com/lixmal/finddupes/pkg/file"
+
+// Summary counts the duplicates found
+type Summary struct {
+	// Groups is the number of groups of duplicates
+	Groups int
+	// Duplicates is the number of redundant files, i.e. all files of the groups except one each
+	Duplicates int
+	// Removed is the number of duplicates matching the rules, removed or to be removed in a dry run.
+	// Files that were skipped or failed to be removed aren't counted, failures are recorded as errors.
+	Removed int
+	// FreedBytes are the bytes freed by removing them
+	FreedBytes int64
+	// ReclaimableBytes are the bytes of independent copies, i.e. distinct inodes, except one per group.
+	// This is the space removing all duplicates could free at most.
+	ReclaimableBytes int64
+	// SharedBytes are the bytes of duplicates that are hard links of another file of their group,
+	// they don't take additional space. Shared extents of reflinked copies aren't detected.
+	SharedBytes int64
+	// DryRun is set if nothing was deleted, also if deletion wasn't confirmed
+	DryRun bool
+}
+
+// countGroup adds the space taken by the duplicates of a group to the summary
+func (s *Summary) countGroup(fileSlice file.Slice) {
+	size := fileSlice[0].Size
+
+	inodes := map[file.Inode]struct{}{}
+	distinct := 0
+	for _, fil := range fileSlice {
+		// aliases are paths of the same inode
+		s.SharedBytes += int64(len(fil.Aliases)) * size
+
+		// unknown inodes, e.g. of archive entries, count as independent copies
+		if fil.Stat == nil {
+			distinct++
+			continue
+		}
+		if _, seen := inodes[fil.Stat.Inode()]; seen {
+			s.SharedBytes += size
+			continue
+		}
+		inodes[fil.Stat.Inode()] = struct{}{}
+		distinct++
+	}
+
+	s.ReclaimableBytes += int64(distinct-1) * size
+}
+
+// Summary returns the counts of the duplicates found by DeleteDuplicates, regardless whether they were deleted
+func (d *Dupe) Summary() Summary {
+	return d.summary
+}
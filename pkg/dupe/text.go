@@ -0,0 +1,55 @@
+package dupe
+
+import "io"
+
+// textReader normalizes text read from r: CRLF line endings are converted to LF and trailing whitespace is removed
+type textReader struct {
+	r   io.Reader
+	buf []byte
+	// pending is whitespace not yet known to be trailing
+	pending []byte
+	out     []byte
+	outBuf  []byte
+	err     error
+}
+
+func newTextReader(r io.Reader) *textReader {
+	return &textReader{r: r, buf: make([]byte, 32*1024)}
+}
+
+func (t *textReader) Read(p []byte) (int, error) {
+	for len(t.out) == 0 {
+		// whitespace pending at the end is trailing
+		if t.err != nil {
+			return 0, t.err
+		}
+
+		n, err := t.r.Read(t.buf)
+		t.normalize(t.buf[:n])
+		t.err = err
+	}
+
+	n := copy(p, t.out)
+	t.out = t.out[n:]
+	return n, nil
+}
+
+// normalize appends the normalized form of b to the output
+func (t *textReader) normalize(b []byte) {
+	out := t.outBuf[:0]
+	for _, c := range b {
+		switch c {
+		case ' ', '\t', '\r':
+			t.pending = append(t.pending, c)
+		case '\n':
+			t.pending = t.pending[:0]
+			out = append(out, c)
+		default:
+			out = append(out, t.pending...)
+			t.pending = t.pending[:0]
+			out = append(out, c)
+		}
+	}
+	t.outBuf = out
+	t.out = out
+}
@@ -0,0 +1,44 @@
+package dupe
+
+import (
+	"github.com/lixmal/finddupes/pkg/file"
+)
+
+// addTransient adds a hashed file only known for this run, e.g. of a reference list, to the tables.
+// It's added directly, as it doesn't modify the stored database, and removed again by removeTransient.
+func (d *Dupe) addTransient(fil *file.File) {
+	if d.database.Files[fil.Size] == nil {
+		d.database.Files[fil.Size] = file.Map{}
+	}
+	d.database.Files[fil.Size][fil.Path] = fil
+	if d.database.Hashes[fil.HashKey()] == nil {
+		d.database.Hashes[fil.HashKey()] = file.Map{}
+	}
+	d.database.Hashes[fil.HashKey()][fil.Path] = fil
+
+	d.transient = append(d.transient, fil)
+}
+
+// removeTransient removes the files only known for this run again, before the database is written
+func (d *Dupe) removeTransient() {
+	for _, fil := range d.transient {
+		delete(d.database.Files[fil.Size], fil.Path)
+		delete(d.database.Hashes[fil.HashKey()], fil.Path)
+	}
+	d.transient = nil
+	d.placeholders = map[*file.File]struct{}{}
+}
+
+// localSurvivor returns the survivor if it's a local file. For placeholders of reference hash lists, another kept
+// file of the group is returned, or nil if none is kept.
+func (d *Dupe) localSurvivor(decisions []decision, survivor *file.File) *file.File {
+	if _, placeholder := d.placeholders[survivor]; !placeholder {
+		return survivor
+	}
+	for _, dec := range decisions {
+		if _, placeholder := d.placeholders[dec.file]; !dec.delete && !placeholder {
+			return dec.file
+		}
+	}
+	return nil
+}
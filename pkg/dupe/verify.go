@@ -0,0 +1,56 @@
+package dupe
+
+import (
+	"log"
+
+	"github.com/lixmal/finddupes/pkg/file"
+)
+
+// verifyTakenOver hashes the files with duplicates completely whose hash was taken over without reading them.
+// Another file can take over the inode, size, mtime and first bytes of a moved one, e.g. if it was rewritten with its
+// mtime preserved, and manifests can be outdated. The hash is corrected then, files that can't be read are hashed
+// again on the next run.
+func (d *Dupe) verifyTakenOver() error {
+	var unverified file.Slice
+	for _, files := range d.database.Hashes {
+		if len(files) < 2 {
+			continue
+		}
+		for _, fil := range files {
+			if fil.Unverified {
+				unverified = append(unverified, fil)
+			}
+		}
+	}
+
+	for _, fil := range unverified {
+		select {
+		case <-d.ctx.Done():
+			return ErrProcessStopped
+		default:
+		}
+
+		if d.verbose() {
+			d.logf("Verifying taken over hash of %s\n", fil.Path)
+		}
+		hash, err := d.hashWithRetries(fil)
+		if err != nil {
+			log.Println(err)
+			d.addError(&HashError{Path: fil.Path, Err: err})
+		} else if hash != fil.Hash {
+			log.Printf("Warning: taken over hash of '%s' doesn't match its content\n", fil.Path)
+		}
+
+		delete(d.database.Hashes[fil.HashKey()], fil.Path)
+		fil.Unverified = false
+		if err != nil {
+			fil.Hash = ""
+			d.database.MarkDirty()
+			continue
+		}
+		fil.Hash = hash
+		d.database.AddHash(fil)
+	}
+
+	return nil
+}
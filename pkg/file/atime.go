@@ -0,0 +1,12 @@
+//go:build aix || dragonfly || linux || openbsd || solaris
+
+package file
+
+import (
+	"syscall"
+	"time"
+)
+
+func statATime(stat *syscall.Stat_t) time.Time {
+	return time.Unix(int64(stat.Atim.Sec), int64(stat.Atim.Nsec))
+}
@@ -0,0 +1,12 @@
+//go:build darwin || freebsd || netbsd
+
+package file
+
+import (
+	"syscall"
+	"time"
+)
+
+func statATime(stat *syscall.Stat_t) time.Time {
+	return time.Unix(int64(stat.Atimespec.Sec), int64(stat.Atimespec.Nsec))
+}
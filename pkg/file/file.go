@@ -1,9 +1,12 @@
 package file
 
 import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/bits"
 	"os"
 	"sort"
-	"syscall"
 	"time"
 )
 
@@ -14,13 +17,131 @@ const (
 	SortDescending
 )
 
+// HashKindPrefix marks hashes calculated over the first bytes of a file only, followed by the number of bytes
+const HashKindPrefix = "prefix:"
+
+// HashKindBlake3 marks blake3 hashes, keyed hashes are followed by HashKindKeyed and an id of the key.
+// Other kinds are appended after a +.
+const (
+	HashKindBlake3 = "blake3"
+	HashKindKeyed  = "-keyed:"
+)
+
+// HashKindCommand marks hashes calculated over the output of a normalize command, followed by the command
+const HashKindCommand = "cmd:"
+
+// HashKindFingerprint marks hashes calculated over content fingerprints of a configured fingerprinter
+const HashKindFingerprint = "fingerprint"
+
+// HashKindText marks hashes of text files calculated over normalized line endings and trailing whitespace
+const HashKindText = "text"
+
 type File struct {
 	Path  string
 	Hash  string
 	Size  int64
 	MTime time.Time
 	Mode  os.FileMode
-	Stat  *syscall.Stat_t
+	Stat  *Stat
+	// MimeType is the detected content type, if enabled
+	MimeType string
+	// HashKind describes how the hash was calculated, empty for xxhash hashes over the full content
+	HashKind string
+	// PrefixHash is the hash of the first bytes of the content, recorded to detect moved files if enabled
+	PrefixHash string
+	// Partial hashes only cover the content read before a read error, they are never compared
+	Partial bool
+	// Unverified is set if the hash was taken over without reading the content, from a moved file or a manifest.
+	// It's verified before the file is acted on.
+	Unverified bool
+	// Reference files are never deleted
+	Reference bool
+	// Root is the scanned path the file was found under
+	Root string
+	// Archive is the path of the archive containing the file, empty for regular files.
+	// The path of archive entries is the archive path followed by !/ and the entry name.
+	Archive string
+	// Aliases are other paths of the same inode found while indexing
+	Aliases []string
+	// LastSeenRun is the id of the last run that found the file while indexing, empty if runs weren't identified
+	LastSeenRun string
+}
+
+// ATime returns the access time recorded when the file was last indexed or stat'ed, or the zero time if unknown
+func (f *File) ATime() time.Time {
+	if f.Stat == nil {
+		return time.Time{}
+	}
+	return f.Stat.ATime
+}
+
+// MarshalJSON encodes the binary hashes in hex
+func (f *File) MarshalJSON() ([]byte, error) {
+	type alias File
+	return json.Marshal(&struct {
+		*alias
+		Hash       string
+		PrefixHash string `json:",omitempty"`
+	}{
+		alias:      (*alias)(f),
+		Hash:       hex.EncodeToString([]byte(f.Hash)),
+		PrefixHash: hex.EncodeToString([]byte(f.PrefixHash)),
+	})
+}
+
+// UnmarshalJSON decodes the hex encoded hashes
+func (f *File) UnmarshalJSON(data []byte) error {
+	type alias File
+	aux := struct {
+		*alias
+		Hash       string
+		PrefixHash string
+	}{
+		alias: (*alias)(f),
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	hash, err := hex.DecodeString(aux.Hash)
+	if err != nil {
+		return fmt.Errorf("decode hash of '%s': %w", f.Path, err)
+	}
+	f.Hash = string(hash)
+
+	prefixHash, err := hex.DecodeString(aux.PrefixHash)
+	if err != nil {
+		return fmt.Errorf("decode prefix hash of '%s': %w", f.Path, err)
+	}
+	f.PrefixHash = string(prefixHash)
+
+	return nil
+}
+
+// HashKey returns the key used to group files by hash. Hashes of different kinds never share a key.
+func (f *File) HashKey() string {
+	if f.HashKind == "" {
+		return f.Hash
+	}
+	return f.HashKind + "\x00" + f.Hash
+}
+
+// HashString returns the hash in hex, prefixed by its kind if any
+func (f *File) HashString() string {
+	return f.ShortHashString(0)
+}
+
+// ShortHashString returns the hash like HashString, with the hex digits truncated to n, 0 means all digits
+func (f *File) ShortHashString(n int) string {
+	digits := hex.EncodeToString([]byte(f.Hash))
+	if n > 0 && n < len(digits) {
+		digits = digits[:n]
+	}
+
+	if f.HashKind == "" {
+		return digits
+	}
+	return f.HashKind + ":" + digits
 }
 
 type Slice []*File
@@ -39,9 +160,10 @@ func (s Slice) SortByPath() Slice {
 	return s
 }
 
-// Sort slice by mod time by ascending order (oldest first) or descending order (youngest first)
+// Sort slice by mod time by ascending order (oldest first) or descending order (youngest first).
+// Files with equal times keep their order.
 func (s Slice) SortByTime(dir direction) Slice {
-	sort.Slice(s, func(i, j int) bool {
+	sort.SliceStable(s, func(i, j int) bool {
 		if dir == SortAscending {
 			return s[i].MTime.Before(s[j].MTime)
 		} else {
@@ -51,6 +173,49 @@ func (s Slice) SortByTime(dir direction) Slice {
 	return s
 }
 
+// Sort slice by access time by ascending order (least recently accessed first) or descending order (most recently accessed first).
+// Files with equal times keep their order.
+func (s Slice) SortByAccessTime(dir direction) Slice {
+	sort.SliceStable(s, func(i, j int) bool {
+		if dir == SortAscending {
+			return s[i].ATime().Before(s[j].ATime())
+		} else {
+			return s[i].ATime().After(s[j].ATime())
+		}
+	})
+	return s
+}
+
+// Sort slice by size by ascending order (smallest first) or descending order (largest first)
+func (s Slice) SortBySize(dir direction) Slice {
+	sort.SliceStable(s, func(i, j int) bool {
+		if dir == SortAscending {
+			return s[i].Size < s[j].Size
+		} else {
+			return s[i].Size > s[j].Size
+		}
+	})
+	return s
+}
+
+// Sort slice by the number of permission bits granted, ascending (most restrictive first) or descending (least restrictive first).
+// Files with equal permissions keep their order.
+func (s Slice) SortByPermissions(dir direction) Slice {
+	sort.SliceStable(s, func(i, j int) bool {
+		if dir == SortAscending {
+			return s[i].permissions() < s[j].permissions()
+		} else {
+			return s[i].permissions() > s[j].permissions()
+		}
+	})
+	return s
+}
+
+// permissions returns the number of permission bits set
+func (f *File) permissions() int {
+	return bits.OnesCount32(uint32(f.Mode.Perm()))
+}
+
 type Map map[string]*File
 
 func (m Map) ToSlice() (s Slice) {
@@ -0,0 +1,46 @@
+package file
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// Stat holds the platform specific file information that is needed, in a portable form
+type Stat struct {
+	Dev   uint64
+	Ino   uint64
+	Nlink uint64
+	Uid   uint32
+	Gid   uint32
+	ATime time.Time
+}
+
+// NewStat extracts the stat information from the file info.
+// It returns nil if the information isn't available, e.g. for filesystems other than the OS one.
+func NewStat(info fs.FileInfo) *Stat {
+	sys, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+
+	return &Stat{
+		Dev:   uint64(sys.Dev),
+		Ino:   uint64(sys.Ino),
+		Nlink: uint64(sys.Nlink),
+		Uid:   sys.Uid,
+		Gid:   sys.Gid,
+		ATime: statATime(sys),
+	}
+}
+
+// Inode identifies a file on a device
+type Inode struct {
+	Dev uint64
+	Ino uint64
+}
+
+// Inode returns the device and inode of the file
+func (s *Stat) Inode() Inode {
+	return Inode{Dev: s.Dev, Ino: s.Ino}
+}
@@ -0,0 +1,40 @@
+//go:build darwin || freebsd || linux || netbsd
+
+package file
+
+import (
+	"runtime"
+	"strings"
+)
+
+// XattrSupported reports whether extended attributes can be read on this platform
+const XattrSupported = true
+
+// finderTags is the extended attribute holding the Finder tags on macOS
+const finderTags = "com.apple.metadata:_kMDItemUserTags"
+
+// HasXattr reports whether the file has the extended attribute name.
+// If name is empty, only attributes set by users count, i.e. user.* attributes or Finder tags on macOS,
+// not those every file carries on some systems, like SELinux labels or quarantine flags.
+// Filesystems without support for extended attributes report none.
+func HasXattr(path, name string) (bool, error) {
+	names, err := listXattr(path)
+	if err != nil {
+		return false, err
+	}
+
+	for _, attr := range names {
+		if name == "" && userXattr(attr) || attr == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// userXattr reports whether the extended attribute is set by users rather than the system
+func userXattr(name string) bool {
+	if runtime.GOOS == "darwin" {
+		return name == finderTags
+	}
+	return strings.HasPrefix(name, "user.")
+}
@@ -0,0 +1,45 @@
+//go:build freebsd || netbsd
+
+package file
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// listXattr returns the names of the extended attributes of the file in the user namespace, prefixed with "user."
+// like on Linux. Attributes of the system namespace are never set by users.
+func listXattr(path string) ([]string, error) {
+	for {
+		size, err := unix.ListxattrNS(path, unix.EXTATTR_NAMESPACE_USER, nil)
+		if errors.Is(err, unix.EOPNOTSUPP) {
+			return nil, nil
+		}
+		if err != nil || size == 0 {
+			return nil, err
+		}
+
+		buf := make([]byte, size)
+		n, err := unix.ListxattrNS(path, unix.EXTATTR_NAMESPACE_USER, buf)
+		// attributes were added in between
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// every name is preceded by its length
+		var names []string
+		for i := 0; i < n; {
+			length := int(buf[i])
+			if i+1+length > n {
+				break
+			}
+			names = append(names, "user."+string(buf[i+1:i+1+length]))
+			i += 1 + length
+		}
+		return names, nil
+	}
+}
@@ -0,0 +1,41 @@
+//go:build darwin || linux
+
+package file
+
+import (
+	"bytes"
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// listXattr returns the names of the extended attributes of the file
+func listXattr(path string) ([]string, error) {
+	for {
+		size, err := unix.Listxattr(path, nil)
+		if errors.Is(err, unix.ENOTSUP) {
+			return nil, nil
+		}
+		if err != nil || size == 0 {
+			return nil, err
+		}
+
+		buf := make([]byte, size)
+		n, err := unix.Listxattr(path, buf)
+		// attributes were added in between
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var names []string
+		for _, attr := range bytes.Split(buf[:n], []byte{0}) {
+			if len(attr) > 0 {
+				names = append(names, string(attr))
+			}
+		}
+		return names, nil
+	}
+}
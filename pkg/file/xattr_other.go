@@ -0,0 +1,11 @@
+//go:build !darwin && !freebsd && !linux && !netbsd
+
+package file
+
+// XattrSupported reports whether extended attributes can be read on this platform
+const XattrSupported = false
+
+// HasXattr always reports no extended attributes, as they aren't supported on this platform
+func HasXattr(path, name string) (bool, error) {
+	return false, nil
+}
@@ -1,9 +1,12 @@
 package misc
 
 import (
+	"hash"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/cespare/xxhash"
 )
@@ -21,10 +24,71 @@ func Hash(path string) (string, error) {
 	}
 	defer Close(path, f)
 
-	h := xxhash.New()
-	if _, err := io.Copy(h, f); err != nil {
+	return HashReader(f)
+}
+
+// HashReader calculates the hash of everything read from r
+func HashReader(r io.Reader) (string, error) {
+	hash, _, err := HashReaderPartial(r)
+	if err != nil {
 		return "", err
 	}
+	return hash, nil
+}
+
+// HashReaderPartial calculates the hash of everything read from r and returns the number of bytes read.
+// On a read error, the hash of the bytes read before is returned along with the error.
+func HashReaderPartial(r io.Reader) (string, int64, error) {
+	return ReadHash(xxhash.New(), r)
+}
+
+// ReadHash calculates the hash of everything read from r with h, like HashReaderPartial
+func ReadHash(h hash.Hash, r io.Reader) (string, int64, error) {
+	n, err := io.Copy(h, r)
+	return string(h.Sum(nil)), n, err
+}
 
-	return string(h.Sum(nil)), nil
+// UnderRoot reports whether path equals root or is located below it
+func UnderRoot(path, root string) bool {
+	sep := string(filepath.Separator)
+	path, root = filepath.Clean(path), filepath.Clean(root)
+
+	switch {
+	case path == root:
+		return true
+	case root == ".":
+		// any relative path not leaving the current directory
+		return !filepath.IsAbs(path) && path != ".." && !strings.HasPrefix(path, ".."+sep)
+	}
+	return strings.HasPrefix(path, strings.TrimSuffix(root, sep)+sep)
+}
+
+// ProgressReader reports the number of bytes read from Reader, at most once every Interval bytes
+type ProgressReader struct {
+	Reader   io.Reader
+	Interval int64
+	Report   func(done int64)
+
+	done     int64
+	reported int64
+}
+
+func (r *ProgressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.done += int64(n)
+	if r.done-r.reported >= r.Interval {
+		r.reported = r.done
+		r.Report(r.done)
+	}
+	return n, err
+}
+
+// Close reports the final number of bytes read, if any progress was reported before.
+// It doesn't close the underlying reader.
+func (r *ProgressReader) Close() error {
+	if r.reported > 0 && r.reported != r.done {
+		r.reported = r.done
+		r.Report(r.done)
+	}
+	return nil
 }
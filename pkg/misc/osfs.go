@@ -0,0 +1,36 @@
+package misc
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// OSFS is a fs.FS for the operating system's filesystem.
+// Unlike os.DirFS it accepts any path, relative or absolute, like the os functions.
+type OSFS struct{}
+
+func (OSFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+func (OSFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (OSFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (OSFS) Glob(pattern string) ([]string, error) {
+	return filepath.Glob(pattern)
+}
+
+// WalkDir walks the tree below root like fs.WalkDir.
+// On the OS filesystem it's filepath.WalkDir, which doesn't follow a symlinked root like fs.WalkDir would.
+func WalkDir(fsys fs.FS, root string, fn fs.WalkDirFunc) error {
+	if _, ok := fsys.(OSFS); ok {
+		return filepath.WalkDir(root, fn)
+	}
+	return fs.WalkDir(fsys, root, fn)
+}
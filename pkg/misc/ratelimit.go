@@ -0,0 +1,68 @@
+package misc
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the bytes per second read by all readers sharing it
+type RateLimiter struct {
+	rate  int64
+	mutex sync.Mutex
+	// next is the time at which the bytes granted so far are read at the configured rate
+	next time.Time
+}
+
+// NewRateLimiter returns a limiter allowing the given number of bytes per second
+func NewRateLimiter(bytesPerSec int64) *RateLimiter {
+	return &RateLimiter{rate: bytesPerSec}
+}
+
+// Wait blocks until reading another n bytes keeps the rate, or the context is done
+func (l *RateLimiter) Wait(ctx context.Context, n int) error {
+	l.mutex.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	l.mutex.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// RateLimitedReader reads from Reader at the rate of Limiter.
+// Reads are split into chunks of at most a second's worth of bytes.
+type RateLimitedReader struct {
+	Ctx     context.Context
+	Reader  io.Reader
+	Limiter *RateLimiter
+}
+
+func (r *RateLimitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > r.Limiter.rate {
+		p = p[:r.Limiter.rate]
+	}
+
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		if waitErr := r.Limiter.Wait(r.Ctx, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
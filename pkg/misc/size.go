@@ -0,0 +1,42 @@
+package misc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits maps unit prefixes to their multiplier, all units are binary
+var sizeUnits = map[string]int64{
+	"":  1,
+	"K": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+	"T": 1 << 40,
+}
+
+// ParseSize parses a size in bytes like 4096, 4K, 4KB or 4KiB.
+// Units are case insensitive and always multiples of 1024.
+func ParseSize(s string) (int64, error) {
+	trimmed := strings.ToUpper(strings.TrimSpace(s))
+	trimmed = strings.TrimSuffix(trimmed, "B")
+	trimmed = strings.TrimSuffix(trimmed, "I")
+
+	unit := ""
+	if n := len(trimmed); n > 0 && (trimmed[n-1] < '0' || trimmed[n-1] > '9') {
+		unit = trimmed[n-1:]
+		trimmed = trimmed[:n-1]
+	}
+
+	multiplier, ok := sizeUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid size '%s': unknown unit", s)
+	}
+
+	value, err := strconv.ParseInt(strings.TrimSpace(trimmed), 10, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+
+	return value * multiplier, nil
+}
@@ -0,0 +1,7 @@
+// Package trash moves files to the trash of the desktop, following the freedesktop.org trash specification.
+package trash
+
+import "errors"
+
+// ErrUnsupported is returned on platforms without a freedesktop.org trash
+var ErrUnsupported = errors.New("trash not supported on this platform")
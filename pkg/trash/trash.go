@@ -0,0 +1,193 @@
+//go:build aix || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package trash
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lixmal/finddupes/pkg/file"
+)
+
+// Supported reports whether files can be moved to the trash on this platform
+const Supported = true
+
+// maxNames is the number of names tried for a file before giving up, if the trash holds files of the same name
+const maxNames = 10000
+
+// Move moves the file at path to the trash and returns its new path.
+// Files on the device of the home directory go to the home trash, others to the trash at the top of their mount.
+// A trash info record stores the original path and the deletion date, so file managers can restore the file.
+func Move(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("trash '%s': %w", path, err)
+	}
+
+	dir, topdir, err := trashDir(path)
+	if err != nil {
+		return "", fmt.Errorf("trash '%s': %w", path, err)
+	}
+
+	trashed, err := moveTo(path, dir, topdir)
+	if err != nil {
+		return "", fmt.Errorf("trash '%s': %w", path, err)
+	}
+	return trashed, nil
+}
+
+// trashDir returns the trash directory for the file at path.
+// For trash directories at the top of a mount, topdir is that mount point, empty for the home trash.
+func trashDir(path string) (string, string, error) {
+	dev, err := device(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	home, err := homeTrash()
+	if err != nil {
+		return "", "", err
+	}
+	// the home trash might not exist yet, compare with the first existing parent
+	existing := home
+	for {
+		if _, err := os.Lstat(existing); err == nil || filepath.Dir(existing) == existing {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	if homeDev, err := device(existing); err == nil && homeDev == dev {
+		return home, "", nil
+	}
+
+	topdir, err := mountPoint(filepath.Dir(path), dev)
+	if err != nil {
+		return "", "", err
+	}
+	uid := strconv.Itoa(os.Getuid())
+
+	// shared trash prepared by the administrator, only valid if it's a sticky directory and no symlink
+	if info, err := os.Lstat(filepath.Join(topdir, ".Trash")); err == nil && info.IsDir() && info.Mode()&os.ModeSticky != 0 {
+		return filepath.Join(topdir, ".Trash", uid), topdir, nil
+	}
+	return filepath.Join(topdir, ".Trash-"+uid), topdir, nil
+}
+
+// homeTrash returns the path of the trash in the home directory
+func homeTrash() (string, error) {
+	if data := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(data) {
+		return filepath.Join(data, "Trash"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "Trash"), nil
+}
+
+// mountPoint returns the topmost parent of dir that is on the given device
+func mountPoint(dir string, dev uint64) (string, error) {
+	for {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir, nil
+		}
+		parentDev, err := device(parent)
+		if err != nil {
+			return "", err
+		}
+		if parentDev != dev {
+			return dir, nil
+		}
+		dir = parent
+	}
+}
+
+// device returns the device the file at path resides on
+func device(path string) (uint64, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return 0, err
+	}
+	stat := file.NewStat(info)
+	if stat == nil {
+		return 0, fmt.Errorf("device of '%s' unknown", path)
+	}
+	return stat.Dev, nil
+}
+
+// moveTo moves the file into the trash directory under a free name and writes its trash info record
+func moveTo(path, dir, topdir string) (string, error) {
+	files := filepath.Join(dir, "files")
+	info := filepath.Join(dir, "info")
+	for _, d := range []string{files, info} {
+		if err := os.MkdirAll(d, 0o700); err != nil {
+			return "", err
+		}
+	}
+
+	// paths in trashes at the top of a mount are relative to it, so the trash stays valid if mounted elsewhere
+	original := path
+	if topdir != "" {
+		if rel, err := filepath.Rel(topdir, path); err == nil && !strings.HasPrefix(rel, "..") {
+			original = rel
+		}
+	}
+	record := fmt.Sprintf("[Trash Info]\nPath=%s\nDeletionDate=%s\n", escapePath(original), time.Now().Format("2006-01-02T15:04:05"))
+
+	base := filepath.Base(path)
+	ext := filepath.Ext(base)
+	for i := 1; i <= maxNames; i++ {
+		name := base
+		if i > 1 {
+			name = fmt.Sprintf("%s.%d%s", strings.TrimSuffix(base, ext), i, ext)
+		}
+
+		// the info record reserves the name
+		infoPath := filepath.Join(info, name+".trashinfo")
+		f, err := os.OpenFile(infoPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		_, err = f.WriteString(record)
+		if err2 := f.Close(); err == nil {
+			err = err2
+		}
+		if err != nil {
+			_ = os.Remove(infoPath)
+			return "", err
+		}
+
+		trashed := filepath.Join(files, name)
+		if _, err := os.Lstat(trashed); err == nil {
+			// stale file without record
+			_ = os.Remove(infoPath)
+			continue
+		}
+		if err := os.Rename(path, trashed); err != nil {
+			_ = os.Remove(infoPath)
+			return "", err
+		}
+		return trashed, nil
+	}
+
+	return "", fmt.Errorf("no free name in trash '%s'", dir)
+}
+
+// escapePath escapes the path as required for trash info records, keeping the separators
+func escapePath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
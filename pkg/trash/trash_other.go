@@ -0,0 +1,13 @@
+//go:build !aix && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+
+package trash
+
+import "fmt"
+
+// Supported reports whether files can be moved to the trash on this platform
+const Supported = false
+
+// Move always fails, as the trash isn't supported on this platform
+func Move(path string) (string, error) {
+	return "", fmt.Errorf("trash '%s': %w", path, ErrUnsupported)
+}
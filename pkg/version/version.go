@@ -0,0 +1,69 @@
+package version
+
+import (
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// Version is set at build time with -ldflags "-X github.com/lixmal/finddupes/pkg/version.Version=v1.2.3".
+// Otherwise the module version from the build info is used, if any.
+var Version = ""
+
+const devel = "(devel)"
+
+// Get returns the version of the running binary
+func Get() string {
+	if Version != "" {
+		return Version
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+
+	return devel
+}
+
+// Compatible reports whether data written by version other can be used by version current without semantic changes.
+// Versions differ significantly if the major version differs, or the minor version for v0 releases.
+// Unknown versions, e.g. development builds, are considered compatible.
+func Compatible(other, current string) bool {
+	otherMajor, otherMinor, ok := parse(other)
+	if !ok {
+		return true
+	}
+	major, minor, ok := parse(current)
+	if !ok {
+		return true
+	}
+
+	if major == 0 && otherMajor == 0 {
+		return minor == otherMinor
+	}
+
+	return major == otherMajor
+}
+
+// parse extracts major and minor from a version like v1.2.3
+func parse(version string) (major, minor int, ok bool) {
+	if !strings.HasPrefix(version, "v") {
+		return 0, 0, false
+	}
+
+	parts := strings.SplitN(version[1:], ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	minor, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return major, minor, true
+}
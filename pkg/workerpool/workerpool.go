@@ -0,0 +1,83 @@
+package workerpool
+
+import (
+	"context"
+	"sync"
+)
+
+// Pool runs a fixed number of workers processing submitted jobs.
+// The workers stop when the pool is closed or the context is cancelled.
+type Pool[T any] struct {
+	ctx    context.Context
+	jobs   chan T
+	wg     sync.WaitGroup
+	closed sync.Once
+}
+
+// New starts workers calling handle for every submitted job.
+// worker identifies the calling worker, from 0 to workers-1.
+// queueDepth is the number of jobs buffered before Submit blocks.
+func New[T any](ctx context.Context, workers, queueDepth int, handle func(worker int, job T)) *Pool[T] {
+	p := &Pool[T]{
+		ctx:  ctx,
+		jobs: make(chan T, queueDepth),
+	}
+
+	p.wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go p.work(w, handle)
+	}
+
+	return p
+}
+
+func (p *Pool[T]) work(worker int, handle func(int, T)) {
+	defer p.wg.Done()
+
+	for {
+		// prefer stopping over taking more work
+		select {
+		case <-p.ctx.Done():
+			return
+		default:
+		}
+
+		select {
+		case <-p.ctx.Done():
+			return
+		case job, ok := <-p.jobs:
+			if !ok {
+				return
+			}
+			handle(worker, job)
+		}
+	}
+}
+
+// Submit queues a job, blocking while the queue is full.
+// It returns the context's error if the pool was cancelled before the job could be queued.
+// Submit must not be called after Close.
+func (p *Pool[T]) Submit(job T) error {
+	select {
+	case <-p.ctx.Done():
+		return p.ctx.Err()
+	default:
+	}
+
+	select {
+	case <-p.ctx.Done():
+		return p.ctx.Err()
+	case p.jobs <- job:
+		return nil
+	}
+}
+
+// Close stops accepting jobs and waits for the workers to finish.
+// Queued jobs are still processed unless the context is cancelled.
+// It is safe to call Close multiple times.
+func (p *Pool[T]) Close() {
+	p.closed.Do(func() {
+		close(p.jobs)
+	})
+	p.wg.Wait()
+}